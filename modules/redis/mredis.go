@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -79,6 +80,7 @@ func NewAndInitialize(info *TInfo) *redis.Client {
 	if _instance == nil {
 		return nil
 	}
+	_instance.AddHook(&t_stats_hook{})
 
 	//
 	redis_ping()
@@ -150,6 +152,35 @@ func redis_ping() bool {
 	return true
 }
 
+// Health check, quiet
+func Ping(timeout time.Duration) error {
+	if _instance == nil {
+		return errors.New("redis not initialized")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return _instance.Ping(ctx).Err()
+}
+
+// Keys matching pattern, using SCAN (not blocking server like KEYS)
+func ScanKeys(pattern string) ([]string, bool) {
+	var ctx = context.Background()
+	var keys []string
+	var cursor uint64 = 0
+	for {
+		values, next, err := _instance.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return nil, false
+		}
+		keys = append(keys, values...)
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, true
+}
+
 //
 func DelWithKey(key string) bool {
 	var ctx = context.Background()
@@ -160,6 +191,43 @@ func DelWithKey(key string) bool {
 	return true
 }
 
+// Pub/sub, message to all subscribers of channel
+func Publish(channel string, message string) bool {
+	var ctx = context.Background()
+	err := _instance.Publish(ctx, channel, message).Err()
+	if err != nil {
+		return false
+	}
+	return true
+}
+
+// Pub/sub, handler called (one goroutine) until stop is closed, reconnects automatically
+func Subscribe(channel string, handler func(message string), stop chan struct{}) bool {
+	var ctx = context.Background()
+	pubsub := _instance.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return false
+	}
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				handler(msg.Payload)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return true
+}
+
 func PushNumber(key string, value int64, keep float32) bool {
 	var ctx = context.Background()
 	err := _instance.SetEx(ctx, key, value, keep_time(keep)).Err()
@@ -169,6 +237,16 @@ func PushNumber(key string, value int64, keep float32) bool {
 	return true
 }
 
+// Set if not exists, false if key exists
+func PushNumberNX(key string, value int64, keep float32) bool {
+	var ctx = context.Background()
+	result, err := _instance.SetNX(ctx, key, value, keep_time(keep)).Result()
+	if err != nil {
+		return false
+	}
+	return result
+}
+
 func GetNumber(key string) (int64, bool) {
 	var ctx = context.Background()
 	val, err := _instance.GetEx(ctx, key, 0).Int64()
@@ -196,6 +274,16 @@ func GetString(key string) (string, bool) {
 	return val, true
 }
 
+// Only if key not exists, false for exists or error
+func PushStringNX(key string, value string, keep float32) bool {
+	var ctx = context.Background()
+	result, err := _instance.SetNX(ctx, key, value, keep_time(keep)).Result()
+	if err != nil {
+		return false
+	}
+	return result
+}
+
 // HMSet is a deprecated version of HSet left for compatibility with Redis 3.
 func PushFields(key string, values map[string]string) bool {
 	var ctx = context.Background()
@@ -248,6 +336,21 @@ func GetJson[T any](key string, value *T) bool {
 	return true
 }
 
+// Only if key not exists, false for exists or error
+func PushJsonNX[T any](key string, values *T, keep float32) bool {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return false
+	}
+	var text = base64.StdEncoding.EncodeToString(data)
+	var ctx = context.Background()
+	result, err := _instance.SetNX(ctx, key, text, keep_time(keep)).Result()
+	if err != nil {
+		return false
+	}
+	return result
+}
+
 func PushJsonData(key string, values []byte, keep float32) bool {
 	var text = base64.StdEncoding.EncodeToString(values)
 	var ctx = context.Background()
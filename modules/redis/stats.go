@@ -0,0 +1,96 @@
+package mredis
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Command latency buckets (seconds)
+var LatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+//
+type TCommandStat struct {
+	Name    string
+	Count   uint64
+	Errors  uint64
+	Sum     float64  // seconds
+	Buckets []uint64 // cumulative, same as LatencyBuckets
+}
+
+var _stats_lock sync.Mutex
+var _stats = make(map[string]*TCommandStat)
+
+// Commands latency, sorted by name
+func Stats() []TCommandStat {
+	_stats_lock.Lock()
+	var list = make([]TCommandStat, 0, len(_stats))
+	for _, v := range _stats {
+		stat := *v
+		stat.Buckets = append([]uint64(nil), v.Buckets...)
+		list = append(list, stat)
+	}
+	_stats_lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func stats_observe(name string, duration time.Duration, err error) {
+	seconds := duration.Seconds()
+
+	_stats_lock.Lock()
+	stat, ok := _stats[name]
+	if !ok {
+		stat = &TCommandStat{
+			Name:    name,
+			Buckets: make([]uint64, len(LatencyBuckets)),
+		}
+		_stats[name] = stat
+	}
+	stat.Count++
+	stat.Sum += seconds
+	// Nil (key not found) is not an error
+	if err != nil && err != redis.Nil {
+		stat.Errors++
+	}
+	for n, le := range LatencyBuckets {
+		if seconds <= le {
+			stat.Buckets[n]++
+		}
+	}
+	_stats_lock.Unlock()
+}
+
+//
+type t_stats_hook struct{}
+
+func (self *t_stats_hook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (self *t_stats_hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		stats_observe(cmd.Name(), time.Since(start), err)
+		return err
+	}
+}
+
+func (self *t_stats_hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		stats_observe("pipeline", time.Since(start), err)
+		return err
+	}
+}
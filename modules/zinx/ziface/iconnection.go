@@ -25,13 +25,18 @@ type IConnection interface {
 	Context() context.Context //返回ctx，用于用户自定义的go程获取连接退出状态
 
 	GetTCPConnection() *net.TCPConn //从当前连接获取原始的socket TCPConn
+	GetNetConnection() net.Conn     //从当前连接获取原始的socket(TCP或unix)
 	GetConnectionID() uint32        //获取当前连接ID
 	RemoteAddr() net.Addr           //获取远程客户端地址信息
 
 	SendMsg(id uint32, data []byte) error       //直接将Message数据发送数据给远程的TCP客户端(无缓冲)
 	SendBufferMsg(id uint32, data []byte) error //直接将Message数据发送给远程的TCP客户端(有缓冲)
+	DroppedMsgNum() uint64                      //缓冲满时被丢弃的消息数量
 
 	SetProperty(key string, value interface{})   //设置链接属性
 	GetProperty(key string) (interface{}, error) //获取链接属性
 	RemoveProperty(key string)                   //移除链接属性
+
+	SetLogTag(tag string) //设置日志标识(如trace id)，附加在该连接的zinx日志中
+	LogTag() string       //获取日志标识，包含连接ID
 }
@@ -21,7 +21,9 @@ type IConnectionManager interface {
 	Remove(connection IConnection)      //删除连接
 	Get(id uint32) (IConnection, error) //利用ConnectionID获取链接
 	MaxLen() int
-	Len() int  //获取当前连接
-	ClearAll() //删除并停止所有链接
+	SetMaxLen(maxnum int) //运行时修改最大连接数量
+	Len() int             //获取当前连接
+	ClearAll()            //删除并停止所有链接
 	ClearOne(id uint32)
+	Range(fn func(connection IConnection) bool) //遍历全部连接，fn返回false时停止
 }
@@ -0,0 +1,14 @@
+// Package ziface 主要提供zinx全部抽象层接口定义.
+//
+// 当前文件描述:
+// @Title  ilogger.go
+// @Description  日志输出接口，zlog全局方法可交由外部日志处理
+package ziface
+
+//ILogger 外部日志接口，由zlog.SetLogger设置，级别过滤由实现方决定
+type ILogger interface {
+	DebugF(format string, v ...interface{})
+	InfoF(format string, v ...interface{})
+	WarnF(format string, v ...interface{})
+	ErrorF(format string, v ...interface{})
+}
@@ -21,4 +21,6 @@ type IMsgHandle interface {
 	AddRouter(id uint32, router IRouter) bool //为消息添加具体的处理逻辑
 	StartWorkerPool()                         //启动worker工作池
 	SendMsgToTaskQueue(request IRequest)      //将消息交给TaskQueue,由worker进行处理
+	TaskQueueLen() int                        //全部TaskQueue中等待处理的消息数量
+	GetMsgStats() IMsgStats                   //消息统计
 }
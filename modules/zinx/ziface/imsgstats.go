@@ -0,0 +1,34 @@
+// Package ziface 主要提供zinx全部抽象层接口定义.
+//
+// 当前文件描述:
+// @Title  imsgstats.go
+// @Description  消息统计，包括按消息ID的收发数量及处理耗时直方图
+package ziface
+
+import "time"
+
+//MsgLatencyBuckets 处理耗时直方图的分桶上限(秒)
+var MsgLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+//MsgIDUnknown 未注册路由的消息ID统一计入该ID，避免客户端任意ID产生无限统计项
+const MsgIDUnknown uint32 = 0xFFFFFFFF
+
+//TMsgStat 单个消息ID的统计快照
+type TMsgStat struct {
+	ID      uint32
+	In      uint64   //收到的消息数量
+	Out     uint64   //发送(写入缓冲)的消息数量
+	Buckets []uint64 //与MsgLatencyBuckets对应的累计数量
+	Count   uint64   //处理次数
+	Sum     float64  //处理总耗时(秒)
+}
+
+/*
+	消息统计抽象层
+*/
+type IMsgStats interface {
+	AddIn(id uint32)                           //收到消息
+	AddOut(id uint32)                          //发送消息
+	Observe(id uint32, duration time.Duration) //记录处理耗时
+	Snapshot() []TMsgStat                      //按消息ID排序的快照
+}
@@ -13,17 +13,22 @@
 // @Author  Aceld - Thu Mar 11 10:32:29 CST 2019
 package ziface
 
+import "net"
+
 //定义服务接口
 type IServer interface {
-	Start()                                   //启动服务器方法
+	Start() error                             //启动服务器方法，监听失败时返回错误
 	Stop()                                    //停止服务器方法
 	Serve()                                   //开启业务服务方法
 	AddRouter(id uint32, router IRouter) bool //路由功能：给当前服务注册一个路由业务方法，供客户端链接处理使用
 	GetConnectionManager() IConnectionManager //得到链接管理
+	GetMsgHandler() IMsgHandle                //得到消息管理(工作池、消息统计)
 	SetDataPtr(data any)
-	SetOnConnectionStart(func(any, IConnection))  //设置该Server的连接创建时Hook函数
-	SetOnConnectionStop(func(any, IConnection))   //设置该Server的连接断开时的Hook函数
-	CallOnConnectionStart(connection IConnection) //调用连接OnConnStart Hook函数
-	CallOnConnectionStop(connection IConnection)  //调用连接OnConnStop Hook函数
+	SetOnConnectionStart(func(any, IConnection))        //设置该Server的连接创建时Hook函数
+	SetOnConnectionStop(func(any, IConnection))         //设置该Server的连接断开时的Hook函数
+	CallOnConnectionStart(connection IConnection)       //调用连接OnConnStart Hook函数
+	CallOnConnectionStop(connection IConnection)        //调用连接OnConnStop Hook函数
+	SetOnConnectionFull(func(any) (uint32, []byte))     //设置连接数达到上限时拒绝连接的消息Hook函数
+	SetOnConnectionRefused(func(any, net.Addr, string)) //设置来源地址被过滤时的Hook函数
 	Packet() IDataPack
 }
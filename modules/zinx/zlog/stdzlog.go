@@ -14,11 +14,42 @@ package zlog
    全局日志对象 StdZinxLog
 */
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"mcmcx.com/mserver/modules/zinx/ziface"
+)
 
 //StdZinxLog 创建全局log
 var StdZinxLog = NewZinxLog(os.Stderr, "", BitDefault)
 
+//外部日志，设置后全局方法不再输出到StdZinxLog
+var external_logger atomic.Value
+
+type t_logger_holder struct {
+	logger ziface.ILogger
+}
+
+//SetLogger 设置外部日志，nil 恢复StdZinxLog
+func SetLogger(logger ziface.ILogger) {
+	external_logger.Store(t_logger_holder{logger: logger})
+}
+
+//GetLogger 当前外部日志，未设置为nil
+func GetLogger() ziface.ILogger {
+	holder, _ := external_logger.Load().(t_logger_holder)
+	return holder.logger
+}
+
+//与Println相同的参数拼接，去掉结尾换行
+func sprintln(v ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+}
+
 //Flags 获取StdZinxLog 标记位
 func Flags() int {
 	return StdZinxLog.Flags()
@@ -56,62 +87,118 @@ func OpenDebug() {
 
 //Debugf ====> Debug <====
 func Debugf(format string, v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.DebugF(format, v...)
+		return
+	}
 	StdZinxLog.Debugf(format, v...)
 }
 
 //Debug Debug
 func Debug(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.DebugF("%s", sprintln(v...))
+		return
+	}
 	StdZinxLog.Debug(v...)
 }
 
 //Infof ====> Info <====
 func Infof(format string, v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.InfoF(format, v...)
+		return
+	}
 	StdZinxLog.Infof(format, v...)
 }
 
 //Info -
 func Info(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.InfoF("%s", sprintln(v...))
+		return
+	}
 	StdZinxLog.Info(v...)
 }
 
 // ====> Warn <====
 func Warnf(format string, v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.WarnF(format, v...)
+		return
+	}
 	StdZinxLog.Warnf(format, v...)
 }
 
 func Warn(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.WarnF("%s", sprintln(v...))
+		return
+	}
 	StdZinxLog.Warn(v...)
 }
 
 // ====> Error <====
 func Errorf(format string, v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.ErrorF(format, v...)
+		return
+	}
 	StdZinxLog.Errorf(format, v...)
 }
 
 func Error(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.ErrorF("%s", sprintln(v...))
+		return
+	}
 	StdZinxLog.Error(v...)
 }
 
 // ====> Fatal 需要终止程序 <====
 func Fatalf(format string, v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.ErrorF("[FATAL] "+format, v...)
+		os.Exit(1)
+	}
 	StdZinxLog.Fatalf(format, v...)
 }
 
 func Fatal(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		logger.ErrorF("[FATAL] %s", sprintln(v...))
+		os.Exit(1)
+	}
 	StdZinxLog.Fatal(v...)
 }
 
 // ====> Panic  <====
 func Panicf(format string, v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		s := fmt.Sprintf(format, v...)
+		logger.ErrorF("[PANIC] %s", s)
+		panic(s)
+	}
 	StdZinxLog.Panicf(format, v...)
 }
 
 func Panic(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		s := sprintln(v...)
+		logger.ErrorF("[PANIC] %s", s)
+		panic(s)
+	}
 	StdZinxLog.Panic(v...)
 }
 
 // ====> Stack  <====
 func Stack(v ...interface{}) {
+	if logger := GetLogger(); logger != nil {
+		buf := make([]byte, LOG_MAX_BUF)
+		n := runtime.Stack(buf, true) //得到当前堆栈信息
+		logger.ErrorF("%s\n%s", fmt.Sprint(v...), string(buf[:n]))
+		return
+	}
 	StdZinxLog.Stack(v...)
 }
 
@@ -1,17 +1,21 @@
 package znet
 
 import (
+	"container/list"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/modules/zinx/zlog"
 	"mcmcx.com/mserver/modules/zinx/zpack"
+	"mcmcx.com/mserver/modules/zinx/zutils"
 )
 
 //Connection 链接
@@ -23,8 +27,8 @@ type Connection struct {
 	MsgChanMaxLen  int32
 	WorkerPoolSize int32
 
-	//当前连接的socket TCP套接字
-	Connection *net.TCPConn
+	//当前连接的socket套接字(TCP或unix)
+	Connection net.Conn
 	//当前连接的ID 也可以称作为SessionID，ID全局唯一
 	ConnectionID uint32
 	//消息管理MsgID和对应处理方法的消息管理模块
@@ -35,6 +39,17 @@ type Connection struct {
 	//有缓冲管道，用于读、写两个goroutine之间的消息通信
 	MsgBufferChan chan []byte
 
+	//SendBufferMsg 缓冲区满时的超时时间及处理策略
+	SendTimeout       time.Duration
+	SendPolicy        string
+	SendBacklogMaxLen int32
+	//backlog策略下的积压队列，由写Goroutine在缓冲空闲时发送
+	backlog       *list.List
+	backlogLock   sync.Mutex
+	backlogSignal chan struct{}
+	//缓冲满时被丢弃的消息数量
+	droppedMsgNum uint64
+
 	sync.RWMutex
 	//链接属性
 	property map[string]interface{}
@@ -42,23 +57,38 @@ type Connection struct {
 	propertyLock sync.Mutex
 	//当前连接的关闭状态
 	isClosed bool
+	//日志标识，由propertyLock保护
+	logTag string
+
+	//客户端地址，经过PROXY protocol时为真实的客户端地址
+	remoteAddr net.Addr
+
+	//来源地址过滤，关闭时释放该IP的连接计数
+	ipFilter *IPFilter
+	ip       net.IP
 }
 
 //NewConnection 创建连接的方法
-func NewConnection(server ziface.IServer, connection *net.TCPConn, id uint32,
+func NewConnection(server ziface.IServer, connection net.Conn, id uint32,
 	workerPoolSize int32, msgChanMaxLen int32,
+	sendTimeout int32, sendPolicy string, sendBacklogMaxLen int32,
 	msgHandler ziface.IMsgHandle) *Connection {
 	//初始化Conn属性
 	c := &Connection{
-		TCPServer:      server,
-		MsgChanMaxLen:  msgChanMaxLen,
-		WorkerPoolSize: workerPoolSize,
-		Connection:     connection,
-		ConnectionID:   id,
-		isClosed:       false,
-		MsgHandler:     msgHandler,
-		MsgBufferChan:  make(chan []byte, msgChanMaxLen),
-		property:       nil,
+		TCPServer:         server,
+		MsgChanMaxLen:     msgChanMaxLen,
+		WorkerPoolSize:    workerPoolSize,
+		Connection:        connection,
+		ConnectionID:      id,
+		isClosed:          false,
+		MsgHandler:        msgHandler,
+		MsgBufferChan:     make(chan []byte, msgChanMaxLen),
+		SendTimeout:       time.Duration(sendTimeout) * time.Millisecond,
+		SendPolicy:        sendPolicy,
+		SendBacklogMaxLen: sendBacklogMaxLen,
+		backlog:           list.New(),
+		backlogSignal:     make(chan struct{}, 1),
+		property:          nil,
 	}
 
 	//将新创建的Conn添加到链接管理中
@@ -68,8 +98,8 @@ func NewConnection(server ziface.IServer, connection *net.TCPConn, id uint32,
 
 //StartWriter 写消息Goroutine， 用户将数据发送给客户端
 func (c *Connection) StartWriter() {
-	fmt.Println("[Writer Goroutine is running]")
-	defer fmt.Println(c.RemoteAddr().String(), "[conn Writer exit!]")
+	zlog.Debug("[Writer Goroutine is running] ", c.LogTag())
+	defer zlog.Debug(c.RemoteAddr().String(), "[conn Writer exit!] ", c.LogTag())
 
 	for {
 		select {
@@ -77,12 +107,20 @@ func (c *Connection) StartWriter() {
 			if ok {
 				//有数据要写给客户端
 				if _, err := c.Connection.Write(data); err != nil {
-					fmt.Println("Send Buff Data error:, ", err, " Conn Writer exit")
+					zlog.Warn("Send Buff Data error:, ", err, " Conn Writer exit, ", c.LogTag())
+					return
+				}
+				//缓冲已空，发送积压队列中的消息
+				if len(c.MsgBufferChan) == 0 && !c.flushBacklog() {
 					return
 				}
 			} else {
-				fmt.Println("msgBuffChan is Closed")
-				break
+				zlog.Debug("msgBuffChan is Closed")
+				return
+			}
+		case <-c.backlogSignal:
+			if len(c.MsgBufferChan) == 0 && !c.flushBacklog() {
+				return
 			}
 		case <-c.ctx.Done():
 			return
@@ -90,10 +128,29 @@ func (c *Connection) StartWriter() {
 	}
 }
 
+//flushBacklog 将积压队列中的消息依次写回客户端
+func (c *Connection) flushBacklog() bool {
+	for {
+		c.backlogLock.Lock()
+		front := c.backlog.Front()
+		if front == nil {
+			c.backlogLock.Unlock()
+			return true
+		}
+		c.backlog.Remove(front)
+		c.backlogLock.Unlock()
+
+		if _, err := c.Connection.Write(front.Value.([]byte)); err != nil {
+			zlog.Warn("Send Backlog Data error:, ", err, " Conn Writer exit, ", c.LogTag())
+			return false
+		}
+	}
+}
+
 //StartReader 读消息Goroutine，用于从客户端中读取数据
 func (c *Connection) StartReader() {
-	fmt.Println("[Reader Goroutine is running]")
-	defer fmt.Println(c.RemoteAddr().String(), "[conn Reader exit!]")
+	zlog.Debug("[Reader Goroutine is running] ", c.LogTag())
+	defer zlog.Debug(c.RemoteAddr().String(), "[conn Reader exit!] ", c.LogTag())
 	defer c.Close()
 
 	// 创建拆包解包的对象
@@ -114,7 +171,7 @@ func (c *Connection) StartReader() {
 					//nothing
 					return
 				} else {
-					fmt.Println("[WORKING] (Read) Packet data error: ", err)
+					zlog.Warn("[WORKING] (Read) Packet data error: ", err, ", ", c.LogTag())
 					return
 				}
 			}
@@ -123,7 +180,7 @@ func (c *Connection) StartReader() {
 			//拆包，得到msgID 和 datalen 放在msg中
 			msg, err := c.TCPServer.Packet().Unpack(headData)
 			if err != nil {
-				fmt.Println("[WORKING] (Read) Packet unpack data error: ", err)
+				zlog.Warn("[WORKING] (Read) Packet unpack data error: ", err, ", ", c.LogTag())
 				return
 			}
 
@@ -135,7 +192,7 @@ func (c *Connection) StartReader() {
 					if errors.Is(err, io.EOF) {
 						//nothing
 					} else {
-						fmt.Println("[WORKING] (Read) Message data error: ", err)
+						zlog.Warn("[WORKING] (Read) Message data error: ", err, ", ", c.LogTag())
 						return
 					}
 				}
@@ -182,8 +239,14 @@ func (c *Connection) Close() {
 	c.cancel()
 }
 
-//GetTCPConnection 从当前连接获取原始的socket TCPConn
+//GetTCPConnection 从当前连接获取原始的socket TCPConn，unix socket连接时为nil
 func (c *Connection) GetTCPConnection() *net.TCPConn {
+	conn, _ := c.Connection.(*net.TCPConn)
+	return conn
+}
+
+//GetNetConnection 从当前连接获取原始的socket
+func (c *Connection) GetNetConnection() net.Conn {
 	return c.Connection
 }
 
@@ -194,6 +257,9 @@ func (c *Connection) GetConnectionID() uint32 {
 
 //RemoteAddr 获取远程客户端地址信息
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
 	return c.Connection.RemoteAddr()
 }
 
@@ -209,21 +275,30 @@ func (c *Connection) SendMsg(id uint32, data []byte) error {
 	dp := c.TCPServer.Packet()
 	msg, err := dp.Pack(zpack.NewMsgPackage(id, data))
 	if err != nil {
-		fmt.Println("Pack error msg ID = ", id)
+		zlog.Error("Pack error msg ID = ", id, ", ", c.LogTag())
 		return errors.New("Pack error msg ")
 	}
 
 	//写回客户端
 	_, err = c.Connection.Write(msg)
+	if err == nil {
+		c.MsgHandler.GetMsgStats().AddOut(id)
+	}
 	return err
 }
 
 //SendBufferMsg  发生BufferMsg
 func (c *Connection) SendBufferMsg(id uint32, data []byte) error {
+	err := c.sendBufferMsg(id, data)
+	if err == nil {
+		c.MsgHandler.GetMsgStats().AddOut(id)
+	}
+	return err
+}
+
+func (c *Connection) sendBufferMsg(id uint32, data []byte) error {
 	c.RLock()
 	defer c.RUnlock()
-	idleTimeout := time.NewTimer(5 * time.Millisecond)
-	defer idleTimeout.Stop()
 
 	if c.isClosed == true {
 		return errors.New("Connection closed when send buff msg")
@@ -233,21 +308,84 @@ func (c *Connection) SendBufferMsg(id uint32, data []byte) error {
 	dp := c.TCPServer.Packet()
 	msg, err := dp.Pack(zpack.NewMsgPackage(id, data))
 	if err != nil {
-		fmt.Println("Pack error msg ID = ", id)
+		zlog.Error("Pack error msg ID = ", id, ", ", c.LogTag())
 		return errors.New("Pack error msg ")
 	}
 
+	switch c.SendPolicy {
+	case zutils.ZSERVER_SEND_BLOCK:
+		//阻塞直到写入缓冲或连接关闭
+		select {
+		case c.MsgBufferChan <- msg:
+			return nil
+		case <-c.ctx.Done():
+			return errors.New("Connection closed when send buff msg")
+		}
+	case zutils.ZSERVER_SEND_BACKLOG:
+		//积压队列不为空时直接排队，保证消息顺序
+		switch c.pushBacklog(msg, false) {
+		case 1:
+			return nil
+		case -1:
+			atomic.AddUint64(&c.droppedMsgNum, 1)
+			return errors.New("send buff msg backlog full")
+		}
+	}
+
+	idleTimeout := time.NewTimer(c.SendTimeout)
+	defer idleTimeout.Stop()
+
 	// 发送超时
 	select {
-	case <-idleTimeout.C:
-		return errors.New("send buff msg timeout")
 	case c.MsgBufferChan <- msg:
 		return nil
+	case <-idleTimeout.C:
 	}
-	//写回客户端
-	//c.msgBuffChan <- msg
 
-	return nil
+	switch c.SendPolicy {
+	case zutils.ZSERVER_SEND_BACKLOG:
+		if c.pushBacklog(msg, true) > 0 {
+			return nil
+		}
+		atomic.AddUint64(&c.droppedMsgNum, 1)
+		return errors.New("send buff msg backlog full")
+	case zutils.ZSERVER_SEND_DISCONNECT:
+		atomic.AddUint64(&c.droppedMsgNum, 1)
+		zlog.Warn("[WORKING] Slow consumer, close ", c.LogTag())
+		c.Close()
+		return errors.New("send buff msg timeout, connection closing")
+	}
+
+	atomic.AddUint64(&c.droppedMsgNum, 1)
+	return errors.New("send buff msg timeout")
+}
+
+//pushBacklog 写入积压队列，force为false时仅在队列不为空时写入
+// 1: 已写入, 0: 队列为空未写入, -1: 队列已满
+func (c *Connection) pushBacklog(msg []byte, force bool) int {
+	c.backlogLock.Lock()
+	if !force && c.backlog.Len() == 0 {
+		c.backlogLock.Unlock()
+		return 0
+	}
+	if c.backlog.Len() >= int(c.SendBacklogMaxLen) {
+		c.backlogLock.Unlock()
+		return -1
+	}
+	c.backlog.PushBack(msg)
+	c.backlogLock.Unlock()
+
+	//通知写Goroutine
+	select {
+	case c.backlogSignal <- struct{}{}:
+	default:
+	}
+	return 1
+}
+
+//DroppedMsgNum 获取缓冲满时被丢弃的消息数量
+func (c *Connection) DroppedMsgNum() uint64 {
+	return atomic.LoadUint64(&c.droppedMsgNum)
 }
 
 //SetProperty 设置链接属性
@@ -281,6 +419,25 @@ func (c *Connection) RemoveProperty(key string) {
 	delete(c.property, key)
 }
 
+//SetLogTag 设置日志标识
+func (c *Connection) SetLogTag(tag string) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+
+	c.logTag = tag
+}
+
+//LogTag 获取日志标识，格式: ConnID = 1[, tag]
+func (c *Connection) LogTag() string {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+
+	if len(c.logTag) == 0 {
+		return fmt.Sprintf("ConnID = %d", c.ConnectionID)
+	}
+	return fmt.Sprintf("ConnID = %d, %s", c.ConnectionID, c.logTag)
+}
+
 //返回ctx，用于用户自定义的go程获取连接退出状态
 func (c *Connection) Context() context.Context {
 	return c.ctx
@@ -298,7 +455,7 @@ func (c *Connection) finalizer() {
 		return
 	}
 
-	fmt.Println("Connection Stop()...ConnID = ", c.ConnectionID)
+	zlog.Debug("Connection Stop()...", c.LogTag())
 
 	// 关闭socket链接
 	_ = c.Connection.Close()
@@ -306,6 +463,10 @@ func (c *Connection) finalizer() {
 	//将链接从连接管理器中删除
 	c.TCPServer.GetConnectionManager().Remove(c)
 
+	if c.ipFilter != nil {
+		c.ipFilter.Release(c.ip)
+	}
+
 	//关闭该链接全部管道
 	close(c.MsgBufferChan)
 	//设置标志位
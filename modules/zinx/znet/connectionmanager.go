@@ -2,10 +2,11 @@ package znet
 
 import (
 	"errors"
-	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/modules/zinx/zlog"
 )
 
 //ConnectionManager 连接管理模块
@@ -31,7 +32,7 @@ func (m *ConnectionManager) Add(connection ziface.IConnection) {
 	m.connections[connection.GetConnectionID()] = connection
 	m.connections_lock.Unlock()
 
-	fmt.Println("connection add to ConnectionManager successfully: connection num = ", m.Len())
+	zlog.Debug("connection add to ConnectionManager successfully: connection num = ", m.Len())
 }
 
 //Remove 删除连接
@@ -41,7 +42,7 @@ func (m *ConnectionManager) Remove(connection ziface.IConnection) {
 	//删除连接信息
 	delete(m.connections, connection.GetConnectionID())
 	m.connections_lock.Unlock()
-	fmt.Println("connection Remove ConnectionID=", connection.GetConnectionID(), " successfully: connection num = ", m.Len())
+	zlog.Debug("connection Remove ", connection.LogTag(), " successfully: connection num = ", m.Len())
 }
 
 //Get 利用ID获取链接
@@ -59,7 +60,12 @@ func (m *ConnectionManager) Get(id uint32) (ziface.IConnection, error) {
 
 //
 func (m *ConnectionManager) MaxLen() int {
-	return int(m.connections_maxnum)
+	return int(atomic.LoadInt32(&m.connections_maxnum))
+}
+
+//SetMaxLen 运行时修改最大连接数量，已有连接不受影响
+func (m *ConnectionManager) SetMaxLen(maxnum int) {
+	atomic.StoreInt32(&m.connections_maxnum, int32(maxnum))
 }
 
 //Len 获取当前连接
@@ -81,7 +87,23 @@ func (m *ConnectionManager) ClearAll() {
 	}
 	m.connections_lock.Unlock()
 
-	fmt.Println("Clear All Connections successfully: connection num = ", m.Len())
+	zlog.Info("Clear All Connections successfully: connection num = ", m.Len())
+}
+
+//Range 遍历全部连接(快照)，fn中可以发送消息或关闭连接
+func (m *ConnectionManager) Range(fn func(connection ziface.IConnection) bool) {
+	m.connections_lock.RLock()
+	list := make([]ziface.IConnection, 0, len(m.connections))
+	for _, conn := range m.connections {
+		list = append(list, conn)
+	}
+	m.connections_lock.RUnlock()
+
+	for _, conn := range list {
+		if !fn(conn) {
+			return
+		}
+	}
 }
 
 //ClearOneConnection  利用ID获取一个链接 并且删除
@@ -94,9 +116,9 @@ func (m *ConnectionManager) ClearOne(id uint32) {
 		//停止
 		conn.Close()
 
-		fmt.Println("Clear Connections ID:  ", id, " succeed")
+		zlog.Debug("Clear Connections ID:  ", id, " succeed")
 		return
 	}
 
-	fmt.Println("Clear Connections ID:  ", id, " error")
+	zlog.Warn("Clear Connections ID:  ", id, " error")
 }
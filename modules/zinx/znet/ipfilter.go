@@ -0,0 +1,254 @@
+package znet
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mcmcx.com/mserver/modules/zinx/zlog"
+)
+
+//IP过滤文件格式
+//	{
+//		"allow": ["10.0.0.0/8", "127.0.0.1"],
+//		"deny": ["192.168.1.0/24"],
+//		"ip_connections_maxnum": 10,
+//		"bans": [{"ip": "1.2.3.4", "expired": 1700000000}]
+//	}
+type TIPFilterBan struct {
+	IP      string `json:"ip"`
+	Expired int64  `json:"expired"` //Unix时间戳(秒)，0表示永久
+}
+
+type TIPFilterConfig struct {
+	Allow               []string       `json:"allow"`
+	Deny                []string       `json:"deny"`
+	IPConnectionsMaxNum *int32         `json:"ip_connections_maxnum"` //0表示不限制，未设置时使用创建时的值
+	Bans                []TIPFilterBan `json:"bans"`
+}
+
+//IPFilter 来源地址过滤，包括允许/拒绝列表、单IP最大连接数及临时封禁
+type IPFilter struct {
+	filename string
+	modtime  time.Time
+
+	lock                sync.RWMutex
+	allow               []*net.IPNet
+	deny                []*net.IPNet
+	ipConnectionsMaxNum int32
+	defaultMaxNum       int32                //创建时的单IP最大连接数，文件未设置时使用
+	bans                map[string]time.Time //文件中的封禁
+	tempBans            map[string]time.Time //运行时添加的封禁
+
+	countsLock sync.Mutex
+	counts     map[string]int32
+}
+
+//NewIPFilter 创建IP过滤，filename为空时仅使用ipConnectionsMaxNum
+func NewIPFilter(filename string, ipConnectionsMaxNum int32) *IPFilter {
+	f := &IPFilter{
+		filename:            filename,
+		ipConnectionsMaxNum: ipConnectionsMaxNum,
+		defaultMaxNum:       ipConnectionsMaxNum,
+		bans:                make(map[string]time.Time),
+		tempBans:            make(map[string]time.Time),
+		counts:              make(map[string]int32),
+	}
+	if len(filename) > 0 {
+		if err := f.Reload(); err != nil {
+			zlog.Error("[INIT] IP filter load error: ", err)
+		}
+	}
+	return f
+}
+
+func parseIPNet(text string) (*net.IPNet, error) {
+	text = strings.TrimSpace(text)
+	if !strings.Contains(text, "/") {
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return nil, errors.New("invalid ip: " + text)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(text)
+	return ipnet, err
+}
+
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, v := range list {
+		ipnet, err := parseIPNet(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ipnet)
+	}
+	return result, nil
+}
+
+func containsIP(list []*net.IPNet, ip net.IP) bool {
+	for _, v := range list {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//Reload 重新加载过滤文件
+func (f *IPFilter) Reload() error {
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.filename)
+	if err != nil {
+		return err
+	}
+
+	var config TIPFilterConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	allow, err := parseIPNets(config.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseIPNets(config.Deny)
+	if err != nil {
+		return err
+	}
+
+	bans := make(map[string]time.Time)
+	for _, v := range config.Bans {
+		ip := net.ParseIP(strings.TrimSpace(v.IP))
+		if ip == nil {
+			return errors.New("invalid ban ip: " + v.IP)
+		}
+		var expired time.Time
+		if v.Expired > 0 {
+			expired = time.Unix(v.Expired, 0)
+		}
+		bans[ip.String()] = expired
+	}
+
+	f.lock.Lock()
+	f.modtime = info.ModTime()
+	f.allow = allow
+	f.deny = deny
+	f.ipConnectionsMaxNum = f.defaultMaxNum
+	if config.IPConnectionsMaxNum != nil {
+		f.ipConnectionsMaxNum = *config.IPConnectionsMaxNum
+	}
+	f.bans = bans
+	f.lock.Unlock()
+	return nil
+}
+
+//CheckReload 清理过期的运行时封禁，文件修改后重新加载
+func (f *IPFilter) CheckReload() {
+	now := time.Now()
+	f.lock.Lock()
+	for ip, expired := range f.tempBans {
+		if !expired.IsZero() && now.After(expired) {
+			delete(f.tempBans, ip)
+		}
+	}
+	f.lock.Unlock()
+
+	if len(f.filename) == 0 {
+		return
+	}
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return
+	}
+
+	f.lock.RLock()
+	modified := !info.ModTime().Equal(f.modtime)
+	f.lock.RUnlock()
+	if !modified {
+		return
+	}
+
+	if err := f.Reload(); err != nil {
+		zlog.Error("[WORKING] IP filter reload error: ", err)
+		return
+	}
+	zlog.Info("[WORKING] IP filter reloaded: ", f.filename)
+}
+
+//Ban 临时封禁IP，duration为0表示永久
+func (f *IPFilter) Ban(ip string, duration time.Duration) {
+	var expired time.Time
+	if duration > 0 {
+		expired = time.Now().Add(duration)
+	}
+	f.lock.Lock()
+	f.tempBans[ip] = expired
+	f.lock.Unlock()
+}
+
+//Unban 解除运行时封禁
+func (f *IPFilter) Unban(ip string) {
+	f.lock.Lock()
+	delete(f.tempBans, ip)
+	f.lock.Unlock()
+}
+
+func banned(bans map[string]time.Time, ip string, now time.Time) bool {
+	expired, ok := bans[ip]
+	return ok && (expired.IsZero() || now.Before(expired))
+}
+
+//Acquire 检查来源地址，通过时计入该IP的连接数，返回拒绝原因
+func (f *IPFilter) Acquire(ip net.IP) (bool, string) {
+	key := ip.String()
+	now := time.Now()
+
+	f.lock.RLock()
+	if banned(f.bans, key, now) || banned(f.tempBans, key, now) {
+		f.lock.RUnlock()
+		return false, "banned"
+	}
+	if containsIP(f.deny, ip) {
+		f.lock.RUnlock()
+		return false, "denied"
+	}
+	if len(f.allow) > 0 && !containsIP(f.allow, ip) {
+		f.lock.RUnlock()
+		return false, "not allowed"
+	}
+	maxnum := f.ipConnectionsMaxNum
+	f.lock.RUnlock()
+
+	f.countsLock.Lock()
+	defer f.countsLock.Unlock()
+	if maxnum > 0 && f.counts[key] >= maxnum {
+		return false, "too many connections"
+	}
+	f.counts[key]++
+	return true, ""
+}
+
+//Release 连接关闭时减少该IP的连接数
+func (f *IPFilter) Release(ip net.IP) {
+	key := ip.String()
+
+	f.countsLock.Lock()
+	defer f.countsLock.Unlock()
+	if f.counts[key] <= 1 {
+		delete(f.counts, key)
+		return
+	}
+	f.counts[key]--
+}
@@ -1,10 +1,10 @@
 package znet
 
 import (
-	"fmt"
-	"strconv"
+	"time"
 
 	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/modules/zinx/zlog"
 )
 
 // MsgHandle -
@@ -13,6 +13,7 @@ type MsgHandle struct {
 	WorkerPoolSize   int32                     //业务工作Worker池的数量
 	WorkerTaskMaxLen int32
 	TaskQueue        []chan ziface.IRequest //Worker负责取任务的消息队列
+	Stats            *MsgStats              //消息统计
 }
 
 //NewMsgHandle 创建MsgHandle
@@ -23,6 +24,7 @@ func NewMsgHandle(workerPoolSize int32, workerTaskMaxLen int32) *MsgHandle {
 		WorkerTaskMaxLen: workerTaskMaxLen,
 		//一个worker对应一个queue
 		TaskQueue: make([]chan ziface.IRequest, workerPoolSize),
+		Stats:     NewMsgStats(),
 	}
 }
 
@@ -42,13 +44,17 @@ func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
 func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
 	handler, ok := mh.Apis[request.GetMsgID()]
 	if !ok {
-		fmt.Println("api msgID = ", request.GetMsgID(), " is not FOUND!")
+		mh.Stats.AddIn(ziface.MsgIDUnknown)
+		zlog.Warn("api msgID = ", request.GetMsgID(), " is not FOUND! ", request.GetConnection().LogTag())
 		return
 	}
+	mh.Stats.AddIn(request.GetMsgID())
 	//绑定路由
 	request.BindRouter(handler)
 	//request中未赋值的index默认值是0
+	start := time.Now()
 	request.Next()
+	mh.Stats.Observe(request.GetMsgID(), time.Since(start))
 
 	//执行对应处理方法
 	//handler.PreHandle(request)
@@ -56,11 +62,25 @@ func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
 	//handler.PostHandle(request)
 }
 
+//TaskQueueLen 全部TaskQueue中等待处理的消息数量
+func (mh *MsgHandle) TaskQueueLen() int {
+	num := 0
+	for _, queue := range mh.TaskQueue {
+		num += len(queue)
+	}
+	return num
+}
+
+//GetMsgStats 消息统计
+func (mh *MsgHandle) GetMsgStats() ziface.IMsgStats {
+	return mh.Stats
+}
+
 //AddRouter 为消息添加具体的处理逻辑
 func (mh *MsgHandle) AddRouter(id uint32, router ziface.IRouter) bool {
 	//1 判断当前msg绑定的API处理方法是否已经存在
 	if _, ok := mh.Apis[id]; ok {
-		println("[INIT] (Server) Repeated handler, ID:" + strconv.Itoa(int(id)))
+		zlog.Warn("[INIT] (Server) Repeated handler, ID:", id)
 		return false
 	}
 
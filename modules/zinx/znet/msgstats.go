@@ -0,0 +1,82 @@
+package znet
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"mcmcx.com/mserver/modules/zinx/ziface"
+)
+
+//MsgStats 消息统计
+type MsgStats struct {
+	lock  sync.Mutex
+	stats map[uint32]*ziface.TMsgStat
+}
+
+//NewMsgStats 创建消息统计
+func NewMsgStats() *MsgStats {
+	return &MsgStats{
+		stats: make(map[uint32]*ziface.TMsgStat),
+	}
+}
+
+//get 调用方持有锁
+func (s *MsgStats) get(id uint32) *ziface.TMsgStat {
+	stat, ok := s.stats[id]
+	if !ok {
+		stat = &ziface.TMsgStat{
+			ID:      id,
+			Buckets: make([]uint64, len(ziface.MsgLatencyBuckets)),
+		}
+		s.stats[id] = stat
+	}
+	return stat
+}
+
+//AddIn 收到消息
+func (s *MsgStats) AddIn(id uint32) {
+	s.lock.Lock()
+	s.get(id).In++
+	s.lock.Unlock()
+}
+
+//AddOut 发送消息
+func (s *MsgStats) AddOut(id uint32) {
+	s.lock.Lock()
+	s.get(id).Out++
+	s.lock.Unlock()
+}
+
+//Observe 记录处理耗时
+func (s *MsgStats) Observe(id uint32, duration time.Duration) {
+	seconds := duration.Seconds()
+
+	s.lock.Lock()
+	stat := s.get(id)
+	stat.Count++
+	stat.Sum += seconds
+	for i, le := range ziface.MsgLatencyBuckets {
+		if seconds <= le {
+			stat.Buckets[i]++
+		}
+	}
+	s.lock.Unlock()
+}
+
+//Snapshot 按消息ID排序的快照
+func (s *MsgStats) Snapshot() []ziface.TMsgStat {
+	s.lock.Lock()
+	list := make([]ziface.TMsgStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		v := *stat
+		v.Buckets = append([]uint64(nil), stat.Buckets...)
+		list = append(list, v)
+	}
+	s.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
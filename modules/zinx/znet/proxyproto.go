@@ -0,0 +1,133 @@
+package znet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//HAProxy PROXY protocol v1/v2 头部解析
+//https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+
+const (
+	proxyV1MaxLen  = 107
+	proxyV2HeadLen = 16
+)
+
+var proxyV1Prefix = []byte("PROXY ")
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+//ReadProxyHeader 读取PROXY头部，返回真实的客户端地址
+//LOCAL命令或UNKNOWN协议时返回nil，由调用方使用连接本身的地址
+//只读取头部本身的字节，不会多读后续的消息数据
+func ReadProxyHeader(reader io.Reader) (net.Addr, error) {
+	prefix := make([]byte, len(proxyV1Prefix))
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		return readProxyV1(reader)
+	}
+	if bytes.Equal(prefix, proxyV2Signature[:len(prefix)]) {
+		return readProxyV2(reader, prefix)
+	}
+	return nil, errors.New("proxy header not found")
+}
+
+func readProxyV1(reader io.Reader) (net.Addr, error) {
+	//逐字节读取，直到\r\n
+	line := make([]byte, 0, proxyV1MaxLen)
+	b := make([]byte, 1)
+	for {
+		if len(line)+len(proxyV1Prefix) >= proxyV1MaxLen {
+			return nil, errors.New("proxy v1 header too long")
+		}
+		if _, err := io.ReadFull(reader, b); err != nil {
+			return nil, err
+		}
+		if b[0] == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+			break
+		}
+		line = append(line, b[0])
+	}
+
+	//TCP4 src dst sport dport
+	fields := strings.Split(string(line), " ")
+	if len(fields) > 0 && fields[0] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, errors.New("proxy v1 header invalid")
+	}
+
+	ip := net.ParseIP(fields[1])
+	if ip == nil {
+		return nil, errors.New("proxy v1 source address invalid")
+	}
+	port, err := strconv.Atoi(fields[3])
+	if err != nil || port < 0 || port > 0xFFFF {
+		return nil, errors.New("proxy v1 source port invalid")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2(reader io.Reader, prefix []byte) (net.Addr, error) {
+	head := make([]byte, proxyV2HeadLen)
+	copy(head, prefix)
+	if _, err := io.ReadFull(reader, head[len(prefix):]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, errors.New("proxy v2 signature invalid")
+	}
+
+	version := head[12] >> 4
+	command := head[12] & 0x0F
+	family := head[13]
+	length := binary.BigEndian.Uint16(head[14:16])
+	if version != 2 {
+		return nil, errors.New("proxy v2 version invalid")
+	}
+
+	//地址数据(包括TLV)必须全部读出
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+
+	//LOCAL: 代理自身的健康检查等
+	if command == 0x00 {
+		return nil, nil
+	}
+	if command != 0x01 {
+		return nil, errors.New("proxy v2 command invalid")
+	}
+
+	switch family {
+	case 0x11: //TCP over IPv4
+		if len(data) < 12 {
+			return nil, errors.New("proxy v2 address invalid")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(data[0:4]),
+			Port: int(binary.BigEndian.Uint16(data[8:10])),
+		}, nil
+	case 0x21: //TCP over IPv6
+		if len(data) < 36 {
+			return nil, errors.New("proxy v2 address invalid")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(data[0:16]),
+			Port: int(binary.BigEndian.Uint16(data[32:34])),
+		}, nil
+	}
+
+	//UNSPEC, UDP, UNIX
+	return nil, nil
+}
@@ -3,9 +3,16 @@ package znet
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/modules/zinx/zlog"
 	"mcmcx.com/mserver/modules/zinx/zpack"
 	"mcmcx.com/mserver/modules/zinx/zutils"
 )
@@ -33,13 +40,20 @@ type TServer struct {
 	Address string
 	//服务绑定的端口
 	Port int
+	//全部监听地址(tcp4、tcp6、unix)，未配置时使用Type、Address、Port
+	Listeners []zutils.TListenerConfig
 
 	//
 	PacketSize        uint32
 	ConnectionsMaxNum int32
-	WorkerPoolSize    int32 //业务工作Worker池的数量
+	FullMode          string //连接数达到上限时的处理方式
+	WorkerPoolSize    int32  //业务工作Worker池的数量
 	WorkerTaskMaxLen  int32
 	MsgChanMaxLen     int32
+	//SendBufferMsg 缓冲满时的超时时间(毫秒)及处理策略
+	SendTimeout       int32
+	SendPolicy        string
+	SendBacklogMaxLen int32
 
 	//
 	//当前Server的消息管理模块，用来绑定MsgID和对应的处理方法
@@ -50,6 +64,24 @@ type TServer struct {
 	OnConnectionStart func(data any, connection ziface.IConnection)
 	//该Server的连接断开时的Hook函数
 	OnConnectionStop func(data any, connection ziface.IConnection)
+	//该Server连接数达到上限时，拒绝连接前发送的消息
+	OnConnectionFull func(data any) (uint32, []byte)
+	//该Server来源地址被过滤时的Hook函数
+	OnConnectionRefused func(data any, address net.Addr, reason string)
+
+	//来源地址过滤
+	ipFilter *IPFilter
+
+	//PROXY protocol，仅信任来自proxyTrusted的头部
+	ProxyProtocol bool
+	proxyTrusted  []*net.IPNet
+
+	//连接ID
+	cID uint32
+
+	//已Accept、未加入连接管理的连接数量
+	slotLock    sync.Mutex
+	slotPending int
 
 	exitChan chan struct{}
 
@@ -70,11 +102,16 @@ func NewServer(config *zutils.TConfig, opts ...Option) ziface.IServer {
 		Type:              config.Type,
 		Address:           config.Address,
 		Port:              config.Port,
+		Listeners:         config.Listeners,
 		PacketSize:        config.PacketSize,
 		ConnectionsMaxNum: config.ConnectionsMaxNum,
+		FullMode:          config.FullMode,
 		WorkerPoolSize:    config.WorkerPoolSize,
 		WorkerTaskMaxLen:  config.WorkerTaskMaxLen,
 		MsgChanMaxLen:     config.MsgChanMaxLen,
+		SendTimeout:       config.SendTimeout,
+		SendPolicy:        config.SendPolicy,
+		SendBacklogMaxLen: config.SendBacklogMaxLen,
 		msgHandler:        NewMsgHandle(config.WorkerPoolSize, config.WorkerTaskMaxLen),
 		connectionManager: NewConnectionManager(config.ConnectionsMaxNum),
 		exitChan:          nil,
@@ -82,6 +119,19 @@ func NewServer(config *zutils.TConfig, opts ...Option) ziface.IServer {
 		data:              nil,
 	}
 
+	if len(config.IPFilterFile) > 0 || config.IPConnectionsMaxNum > 0 {
+		s.ipFilter = NewIPFilter(config.IPFilterFile, config.IPConnectionsMaxNum)
+	}
+
+	if config.ProxyProtocol {
+		trusted, err := parseIPNets(config.ProxyTrusted)
+		if err != nil {
+			zlog.Error("[INIT] Proxy trusted address error: ", err)
+		}
+		s.ProxyProtocol = true
+		s.proxyTrusted = trusted
+	}
+
 	//更替打包方式
 	for _, opt := range opts {
 		opt(s)
@@ -95,83 +145,247 @@ func (s *TServer) SetDataPtr(data interface{}) {
 	s.data = data
 }
 
-//Start 开启网络服务
-func (s *TServer) Start() {
-	fmt.Printf("[START] Server name: %s,listenner at IP: %s, Port %d is starting\n", s.Name, s.Address, s.Port)
+//Start 开启网络服务，任一监听地址失败时关闭已打开的监听并返回错误
+func (s *TServer) Start() error {
+	//1 监听全部服务器地址，共用同一个连接管理、路由及连接数量限制
+	var listeners []net.Listener
+	for _, v := range s.Listeners {
+		zlog.Infof("[START] Server name: %s,listenner at %s: %s, Port %d is starting", s.Name, v.Type, v.Address, v.Port)
+		listener, err := listen(v)
+		if err != nil {
+			zlog.Error("[START] Server name: ", s.Name, ", listen ", v.Type, " ", v.Address, " error: ", err)
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
 	s.exitChan = make(chan struct{})
 
-	//开启一个go去做服务端Linster业务
-	go func() {
-		//0 启动worker工作池机制
-		s.msgHandler.StartWorkerPool()
+	//0 启动worker工作池机制
+	s.msgHandler.StartWorkerPool()
 
-		//1 获取一个TCP的Addr
-		addr, err := net.ResolveTCPAddr(s.Type, fmt.Sprintf("%s:%d", s.Address, s.Port))
-		if err != nil {
-			fmt.Println("[WORKING] resolve tcp addr err: ", err)
-			return
-		}
+	//3 启动server网络连接业务
+	for _, listener := range listeners {
+		go s.acceptLoop(listener)
+	}
 
-		//2 监听服务器地址
-		listener, err := net.ListenTCP(s.Type, addr)
-		if err != nil {
-			panic(err)
-		}
+	//已经监听成功
+	zlog.Info("[WORKING] start Zinx server  (", s.Name, ") success, now listenning...")
 
-		//已经监听成功
-		fmt.Println("[WORKING] start Zinx server  (", s.Name, ") success, now listenning...")
-
-		//TODO server.go 应该有一个自动生成ID的方法
-		var cID uint32
-		cID = 0
-
-		go func() {
-			//3 启动server网络连接业务
-			for {
-				//3.1 设置服务器最大连接控制,如果超过最大连接，则等待
-				if s.connectionManager.Len() >= int(s.ConnectionsMaxNum) {
-					fmt.Println("[WORKING] Exceeded the ConnectionMaxCount:", s.ConnectionsMaxNum, ", Wait:", AcceptDelay.duration)
-					AcceptDelay.Delay()
-					continue
+	go func() {
+		//定时检查IP过滤文件
+		if s.ipFilter != nil {
+			go func() {
+				ticker := time.NewTicker(zutils.ZSERVER_IPFILTER_RELOAD * time.Second)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						s.ipFilter.CheckReload()
+					case <-s.exitChan:
+						return
+					}
 				}
+			}()
+		}
 
-				//3.2 阻塞等待客户端建立连接请求
-				conn, err := listener.AcceptTCP()
+		select {
+		case <-s.exitChan:
+			for _, listener := range listeners {
+				err := listener.Close()
 				if err != nil {
-					//Go 1.16+
-					if errors.Is(err, net.ErrClosed) {
-						fmt.Println("[WORKING] Listener closed")
-						return
-					}
-					fmt.Println("[WORKING] Accept error: ", err)
-					AcceptDelay.Delay()
-					continue
+					zlog.Error("[WORKING] Listener close, error :", err)
 				}
+			}
+		}
+	}()
+	return nil
+}
 
-				AcceptDelay.Reset()
+//listen 监听一个服务器地址，unix类型的Address为socket文件路径
+func listen(config zutils.TListenerConfig) (net.Listener, error) {
+	switch config.Type {
+	case zutils.ZSERVER_UNIX:
+		//仅删除上次未清理的socket文件(无进程监听)
+		if err := removeStaleSocket(config.Address); err != nil {
+			return nil, err
+		}
+		return net.Listen(config.Type, config.Address)
+	}
 
-				//3.3 处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
-				dealConn := NewConnection(s, conn, cID, s.WorkerPoolSize, s.MsgChanMaxLen, s.msgHandler)
-				cID++
+	//2 获取一个TCP的Addr
+	addr, err := net.ResolveTCPAddr(config.Type, net.JoinHostPort(config.Address, strconv.Itoa(config.Port)))
+	if err != nil {
+		zlog.Error("[WORKING] resolve tcp addr err: ", err)
+		return nil, err
+	}
+	return net.ListenTCP(config.Type, addr)
+}
 
-				//3.4 启动当前链接的处理业务
-				go dealConn.Start()
-			}
-		}()
+//removeStaleSocket 路径不是socket文件或仍在使用时返回错误
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unix listener path exists and is not a socket: %s", path)
+	}
+	if conn, err := net.DialTimeout(zutils.ZSERVER_UNIX, path, time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("unix socket in use: %s", path)
+	}
+	return os.Remove(path)
+}
 
-		select {
-		case <-s.exitChan:
-			err := listener.Close()
-			if err != nil {
-				fmt.Println("[WORKING] Listener close, error :", err)
+//acceptLoop 接受一个监听地址上的连接
+func (s *TServer) acceptLoop(listener net.Listener) {
+	for {
+		//3.1 设置服务器最大连接控制,如果超过最大连接，则等待
+		if s.FullMode != zutils.ZSERVER_FULL_REJECT &&
+			s.connectionManager.Len() >= s.connectionManager.MaxLen() {
+			zlog.Warn("[WORKING] Exceeded the ConnectionMaxCount:", s.connectionManager.MaxLen(), ", Wait:", AcceptDelay.duration)
+			AcceptDelay.Delay()
+			continue
+		}
+
+		//3.2 阻塞等待客户端建立连接请求
+		conn, err := listener.Accept()
+		if err != nil {
+			//Go 1.16+
+			if errors.Is(err, net.ErrClosed) {
+				zlog.Info("[WORKING] Listener closed: ", listener.Addr())
+				return
 			}
+			zlog.Error("[WORKING] Accept error: ", err)
+			AcceptDelay.Delay()
+			continue
 		}
-	}()
+
+		AcceptDelay.Reset()
+
+		//3.3 处理该新连接请求的 业务 方法，PROXY头部读取可能阻塞，交给单独的go处理
+		go s.handleConnection(conn)
+	}
+}
+
+//handleConnection 解析PROXY头部、过滤来源地址并创建连接
+func (s *TServer) handleConnection(conn net.Conn) {
+	var address net.Addr = conn.RemoteAddr()
+
+	//unix socket为本机内部服务，不做PROXY解析及来源地址过滤
+	tcpAddr, isTCP := address.(*net.TCPAddr)
+
+	//来自可信代理的连接，读取PROXY头部得到真实的客户端地址
+	if isTCP && s.ProxyProtocol && containsIP(s.proxyTrusted, tcpAddr.IP) {
+		_ = conn.SetReadDeadline(time.Now().Add(zutils.ZSERVER_PROXY_TIMEOUT * time.Second))
+		source, err := ReadProxyHeader(conn)
+		_ = conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			zlog.Warn("[WORKING] Read proxy header error: ", err, ", Address:", address)
+			_ = conn.Close()
+			return
+		}
+		if source != nil {
+			address = source
+		}
+	}
+
+	//来源地址过滤
+	var ip net.IP
+	filter := s.ipFilter
+	if isTCP {
+		ip = address.(*net.TCPAddr).IP
+	} else {
+		filter = nil
+	}
+	if filter != nil {
+		if ok, reason := filter.Acquire(ip); !ok {
+			s.CallOnConnectionRefused(address, reason)
+			_ = conn.Close()
+			return
+		}
+	}
+
+	//预留连接数量(多个监听地址共用)，超过最大连接时发送拒绝消息后关闭
+	//等待模式下acceptLoop已等待，仍超过时(多个监听同时Accept)同样拒绝
+	if !s.acquireSlot() {
+		zlog.Warn("[WORKING] Exceeded the ConnectionMaxCount:", s.connectionManager.MaxLen(), ", Reject:", address)
+		if filter != nil {
+			filter.Release(ip)
+		}
+		s.rejectConnection(conn)
+		return
+	}
+
+	dealConn := NewConnection(s, conn, atomic.AddUint32(&s.cID, 1)-1, s.WorkerPoolSize, s.MsgChanMaxLen,
+		s.SendTimeout, s.SendPolicy, s.SendBacklogMaxLen, s.msgHandler)
+	//已加入连接管理，计入Len()
+	s.releaseSlot()
+	dealConn.remoteAddr = address
+	if filter != nil {
+		dealConn.ipFilter = filter
+		dealConn.ip = ip
+	}
+
+	//3.4 启动当前链接的处理业务
+	dealConn.Start()
+}
+
+//acquireSlot 预留一个连接数量，已有连接及预留数量达到上限时返回false
+func (s *TServer) acquireSlot() bool {
+	s.slotLock.Lock()
+	defer s.slotLock.Unlock()
+
+	if s.connectionManager.Len()+s.slotPending >= s.connectionManager.MaxLen() {
+		return false
+	}
+	s.slotPending++
+	return true
+}
+
+//releaseSlot 释放预留，连接已加入连接管理
+func (s *TServer) releaseSlot() {
+	s.slotLock.Lock()
+	s.slotPending--
+	s.slotLock.Unlock()
+}
+
+//rejectConnection 发送服务器已满消息，然后关闭连接
+func (s *TServer) rejectConnection(conn net.Conn) {
+	defer conn.Close()
+
+	if s.OnConnectionFull == nil {
+		return
+	}
+
+	id, data := s.OnConnectionFull(s.data)
+	msg, err := s.packet.Pack(zpack.NewMsgPackage(id, data))
+	if err != nil {
+		zlog.Error("Pack error msg ID = ", id)
+		return
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(msg); err != nil {
+		return
+	}
+
+	//半关闭后丢弃客户端已发送的数据，避免RST导致消息丢失
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+	_, _ = io.CopyN(io.Discard, conn, int64(s.PacketSize))
 }
 
 //Stop 停止服务
 func (s *TServer) Stop() {
-	fmt.Println("[STOP] Zinx server , name :", s.Name)
+	zlog.Info("[STOP] Zinx server , name :", s.Name)
 
 	//将其他需要清理的连接信息或者其他信息 也要一并停止或者清理
 	s.connectionManager.ClearAll()
@@ -181,7 +395,9 @@ func (s *TServer) Stop() {
 
 //Serve 运行服务
 func (s *TServer) Serve() {
-	s.Start()
+	if err := s.Start(); err != nil {
+		return
+	}
 
 	//TODO Server.Serve() 是否在启动服务的时候 还要处理其他的事情呢 可以在这里添加
 
@@ -199,6 +415,11 @@ func (s *TServer) GetConnectionManager() ziface.IConnectionManager {
 	return s.connectionManager
 }
 
+//GetMsgHandler 得到消息管理
+func (s *TServer) GetMsgHandler() ziface.IMsgHandle {
+	return s.msgHandler
+}
+
 //SetOnConnectionStart 设置该Server的连接创建时Hook函数
 func (s *TServer) SetOnConnectionStart(hookFunc func(any, ziface.IConnection)) {
 	s.OnConnectionStart = hookFunc
@@ -209,6 +430,30 @@ func (s *TServer) SetOnConnectionStop(hookFunc func(any, ziface.IConnection)) {
 	s.OnConnectionStop = hookFunc
 }
 
+//SetOnConnectionFull 设置该Server连接数达到上限时拒绝连接的消息Hook函数
+func (s *TServer) SetOnConnectionFull(hookFunc func(any) (uint32, []byte)) {
+	s.OnConnectionFull = hookFunc
+}
+
+//SetOnConnectionRefused 设置该Server来源地址被过滤时的Hook函数
+func (s *TServer) SetOnConnectionRefused(hookFunc func(any, net.Addr, string)) {
+	s.OnConnectionRefused = hookFunc
+}
+
+//CallOnConnectionRefused 调用来源地址被过滤时的Hook函数
+func (s *TServer) CallOnConnectionRefused(address net.Addr, reason string) {
+	if s.OnConnectionRefused != nil {
+		s.OnConnectionRefused(s.data, address, reason)
+	} else {
+		zlog.Warn("[WORKING] Connection refused:", address, ", Reason:", reason)
+	}
+}
+
+//GetIPFilter 得到来源地址过滤，未配置时为nil
+func (s *TServer) GetIPFilter() *IPFilter {
+	return s.ipFilter
+}
+
 //CallOnConnectionStart 调用连接OnConnectionStart Hook函数
 func (s *TServer) CallOnConnectionStart(connection ziface.IConnection) {
 	if s.OnConnectionStart != nil {
@@ -9,6 +9,13 @@ import (
 	"mcmcx.com/mserver/modules/zinx/zlog"
 )
 
+//监听地址，unix类型时Address为socket文件路径
+type TListenerConfig struct {
+	Type    string `json:"type"`    //tcp,tcp4,tcp6,unix
+	Address string `json:"address"` //监听的IP或unix socket文件路径
+	Port    int    `json:"port"`    //监听的端口，unix类型时忽略
+}
+
 //
 type TConfig struct {
 
@@ -16,16 +23,29 @@ type TConfig struct {
 	Type    string `json:"type"`    //tcp版本:tcp,tcp4,tcp6
 	Address string `json:"address"` //当前服务器主机监听的IP
 	Port    int    `json:"port"`    //当前服务器监听的端口
+	//多个监听地址，共用连接管理、路由及连接数量限制，为空时使用Type、Address、Port
+	Listeners []TListenerConfig `json:"listeners"`
 
 	//服务器可选配置
 	Name    string `json:"name"`    //当前服务器的名称
 	Version string `json:"version"` //版本
 	//
 	ConnectionsMaxNum int32  `json:"connections_maxnum"` //最大连接数量
-	PacketSize        uint32 `json:"packet_size"`        //当前框架数据包的最大尺寸
-	WorkerPoolSize    int32  //业务工作Worker池的数量
-	WorkerTaskMaxLen  int32  //业务工作Worker对应负责的任务队列最大任务存储数量
-	MsgChanMaxLen     int32  //SendBuffMsg发送消息的缓冲最大长度
+	FullMode          string `json:"full_mode"`          //连接数达到上限时的处理方式:wait,reject
+	//
+	IPFilterFile        string `json:"ip_filter"`             //IP过滤文件(允许/拒绝列表及封禁)，支持热加载
+	IPConnectionsMaxNum int32  `json:"ip_connections_maxnum"` //单个IP最大连接数量，0表示不限制
+	//
+	ProxyProtocol    bool     `json:"proxy_protocol"` //是否解析HAProxy PROXY protocol v1/v2头部
+	ProxyTrusted     []string `json:"proxy_trusted"`  //可信代理地址(CIDR)，仅解析来自这些地址的头部
+	PacketSize       uint32   `json:"packet_size"`    //当前框架数据包的最大尺寸
+	WorkerPoolSize   int32    //业务工作Worker池的数量
+	WorkerTaskMaxLen int32    //业务工作Worker对应负责的任务队列最大任务存储数量
+	MsgChanMaxLen    int32    //SendBuffMsg发送消息的缓冲最大长度
+	//
+	SendTimeout       int32  `json:"send_timeout"`        //SendBuffMsg写入缓冲的超时时间(毫秒)
+	SendPolicy        string `json:"send_policy"`         //缓冲满时的处理策略:drop,block,disconnect,backlog
+	SendBacklogMaxLen int32  `json:"send_backlog_maxlen"` //backlog策略下积压队列最大长度
 }
 
 type TGlobal struct {
@@ -78,12 +98,35 @@ func InitConfig(config *TConfig) {
 		config.Port = 9000
 	}
 
+	if len(config.Listeners) == 0 {
+		config.Listeners = []TListenerConfig{
+			{Type: config.Type, Address: config.Address, Port: config.Port},
+		}
+	}
+	for n := range config.Listeners {
+		v := &config.Listeners[n]
+		if len(v.Type) == 0 {
+			v.Type = ZSERVER_TCP4
+		}
+		if v.Type == ZSERVER_TCP6 && len(v.Address) == 0 {
+			v.Address = "::"
+		} else if v.Type != ZSERVER_UNIX && len(v.Address) == 0 {
+			v.Address = "0.0.0.0"
+		}
+		if v.Type != ZSERVER_UNIX && v.Port == 0 {
+			v.Port = config.Port
+		}
+	}
+
 	if config.PacketSize == 0 {
 		config.PacketSize = ZSERVER_PACKET_SIZE
 	}
 	if config.ConnectionsMaxNum == 0 {
 		config.ConnectionsMaxNum = ZSERVER_CONNECTIONS_NUM
 	}
+	if config.FullMode != ZSERVER_FULL_REJECT {
+		config.FullMode = ZSERVER_FULL_WAIT
+	}
 	if config.WorkerPoolSize == 0 {
 		config.WorkerPoolSize = 10
 	}
@@ -93,6 +136,18 @@ func InitConfig(config *TConfig) {
 	if config.MsgChanMaxLen == 0 {
 		config.MsgChanMaxLen = 1024
 	}
+
+	if config.SendTimeout <= 0 {
+		config.SendTimeout = ZSERVER_SEND_TIMEOUT
+	}
+	switch config.SendPolicy {
+	case ZSERVER_SEND_DROP, ZSERVER_SEND_BLOCK, ZSERVER_SEND_DISCONNECT, ZSERVER_SEND_BACKLOG:
+	default:
+		config.SendPolicy = ZSERVER_SEND_DROP
+	}
+	if config.SendBacklogMaxLen <= 0 {
+		config.SendBacklogMaxLen = ZSERVER_SEND_BACKLOG_NUM
+	}
 }
 
 //LoadConfig 读取用户的配置文件
@@ -18,6 +18,7 @@ const (
 	ZSERVER_TCP  = "tcp"
 	ZSERVER_TCP4 = "tcp4"
 	ZSERVER_TCP6 = "tcp6"
+	ZSERVER_UNIX = "unix"
 )
 
 const (
@@ -25,6 +26,30 @@ const (
 	ZSERVER_PACKET_SIZE     = 4096
 )
 
+//SendBufferMsg 缓冲区满时的处理策略
+const (
+	ZSERVER_SEND_DROP       = "drop"       //超时后丢弃消息
+	ZSERVER_SEND_BLOCK      = "block"      //阻塞直到写入或连接关闭
+	ZSERVER_SEND_DISCONNECT = "disconnect" //超时后断开慢速连接
+	ZSERVER_SEND_BACKLOG    = "backlog"    //超时后写入有界的连接积压队列
+)
+
+//连接数达到上限时的处理方式
+const (
+	ZSERVER_FULL_WAIT   = "wait"   //暂停Accept，等待连接释放
+	ZSERVER_FULL_REJECT = "reject" //Accept后发送服务器已满消息并关闭
+)
+
+const (
+	ZSERVER_IPFILTER_RELOAD = 5 //IP过滤文件检查间隔(秒)
+	ZSERVER_PROXY_TIMEOUT   = 3 //读取PROXY头部的超时时间(秒)
+)
+
+const (
+	ZSERVER_SEND_TIMEOUT     = 5    //毫秒
+	ZSERVER_SEND_BACKLOG_NUM = 1024 //积压队列最大长度
+)
+
 //
 var ZServer_LogDir = "logs"
 var ZServer_ConfFile = "conf/zinx.json"
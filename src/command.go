@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Commands
+const (
+	COMMAND_ALL   = "all"   // login and game in one process
+	COMMAND_LOGIN = "login" // http, https
+	COMMAND_GAME  = "game"  // game servers
+)
+
+//
+type t_command struct {
+	name string
+
+	// Config files
+	server_config     string // http, https, redis
+	gameserver_config string
+	log_config        string // optional
+
+	// gin.DebugMode, gin.ReleaseMode, gin.TestMode
+	mode string
+
+	// Components
+	http       bool
+	https      bool
+	gameserver bool
+
+	// Game only process, /metrics listen address (empty: disabled)
+	metrics string
+}
+
+func (self *t_command) validate() error {
+	switch self.mode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		return fmt.Errorf("invalid mode: %s (debug, release, test)", self.mode)
+	}
+
+	if !self.http && !self.https && !self.gameserver {
+		return errors.New("no component enabled")
+	}
+
+	if len(self.server_config) == 0 {
+		return errors.New("server config required")
+	}
+	if _, err := os.Stat(self.server_config); err != nil {
+		return fmt.Errorf("server config: %s", err.Error())
+	}
+	if len(self.log_config) > 0 {
+		if _, err := os.Stat(self.log_config); err != nil {
+			return fmt.Errorf("log config: %s", err.Error())
+		}
+	}
+	if self.gameserver {
+		if len(self.gameserver_config) == 0 {
+			return errors.New("gameserver config required")
+		}
+		if _, err := os.Stat(self.gameserver_config); err != nil {
+			return fmt.Errorf("gameserver config: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [%s|%s|%s] [flags]\n", os.Args[0], COMMAND_ALL, COMMAND_LOGIN, COMMAND_GAME)
+	fmt.Fprintf(os.Stderr, "  %s\tlogin and game servers (default)\n", COMMAND_ALL)
+	fmt.Fprintf(os.Stderr, "  %s\tHTTP/HTTPS login server\n", COMMAND_LOGIN)
+	fmt.Fprintf(os.Stderr, "  %s\tgame servers\n", COMMAND_GAME)
+	fmt.Fprintf(os.Stderr, "Run '%s <command> -h' for command flags\n", os.Args[0])
+}
+
+// Log file name tag, login and game processes of one working directory write own files
+// (all: none, <name>_<date>.log)
+func (self *t_command) log_tag() string {
+	if self.name == COMMAND_ALL {
+		return ""
+	}
+	return self.name
+}
+
+// args without program name, default command is all
+func parse_command(args []string) (*t_command, error) {
+	var name = COMMAND_ALL
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		name = args[0]
+		args = args[1:]
+	}
+
+	var command = &t_command{name: name}
+	var flags = flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&command.server_config, "config", "data/ServerInfo.json", "server config file (http, https, redis)")
+	flags.StringVar(&command.mode, "mode", gin.DebugMode, "run mode (debug, release, test)")
+	flags.StringVar(&command.log_config, "log", "data/LogInfo.json", "log config file (format), empty for default")
+
+	switch name {
+	case COMMAND_ALL:
+		flags.StringVar(&command.gameserver_config, "gameserver", "data/GameServerInfo.json", "game server config file")
+		flags.BoolVar(&command.http, "http", true, "enable http server")
+		flags.BoolVar(&command.https, "https", true, "enable https server")
+		flags.BoolVar(&command.gameserver, "game", true, "enable game servers")
+	case COMMAND_LOGIN:
+		flags.BoolVar(&command.http, "http", true, "enable http server")
+		flags.BoolVar(&command.https, "https", true, "enable https server")
+	case COMMAND_GAME:
+		flags.StringVar(&command.gameserver_config, "gameserver", "data/GameServerInfo.json", "game server config file")
+		flags.StringVar(&command.metrics, "metrics", "", "metrics listen address (e.g. 127.0.0.1:9100), empty to disable")
+		command.gameserver = true
+	default:
+		usage()
+		return nil, fmt.Errorf("unknown command: %s", name)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if err := command.validate(); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	mredis "mcmcx.com/mserver/modules/redis"
+	"mcmcx.com/mserver/src/util"
+)
+
+// Account status (same value in DBUserData.Status, < 0: auth refused)
+const (
+	ACCOUNT_STATUS_ACTIVE    = 0
+	ACCOUNT_STATUS_SUSPENDED = -1
+	ACCOUNT_STATUS_CLOSED    = -2 // final, name stays reserved
+)
+
+// (Redis) Registered account, account_<idx>, name index account_name_<name>
+type DBAccount struct {
+	IDX          string `json:"idx"`  //10 account idx
+	Name         string `json:"name"` //lowercase, unique
+	PassHash     string `json:"pass_hash"`
+	Status       int    `json:"status"`
+	StatusReason string `json:"status_reason"`
+	StatusTime   int64  `json:"status_time"`
+	Timestamp    int64  `json:"timestamp"` //create timestamp
+	TimeLast     string `json:"time_last"` //(UPDATE AUTO)
+}
+
+func AccountStatusName(status int) string {
+	switch status {
+	case ACCOUNT_STATUS_ACTIVE:
+		return "active"
+	case ACCOUNT_STATUS_SUSPENDED:
+		return "suspended"
+	case ACCOUNT_STATUS_CLOSED:
+		return "closed"
+	}
+	return "unknown"
+}
+
+func AccountStatusParse(name string) (int, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "active":
+		return ACCOUNT_STATUS_ACTIVE, true
+	case "suspended":
+		return ACCOUNT_STATUS_SUSPENDED, true
+	case "closed":
+		return ACCOUNT_STATUS_CLOSED, true
+	}
+	return ACCOUNT_STATUS_ACTIVE, false
+}
+
+// active <-> suspended, active or suspended -> closed
+func AccountStatusAllowed(from int, to int) bool {
+	if from == ACCOUNT_STATUS_CLOSED {
+		return false
+	}
+	switch to {
+	case ACCOUNT_STATUS_ACTIVE, ACCOUNT_STATUS_SUSPENDED, ACCOUNT_STATUS_CLOSED:
+		return from != to
+	}
+	return false
+}
+
+func DB_get_account(idx string) *DBAccount {
+	var data DBAccount
+	result := mredis.GetJson[DBAccount]("account_"+idx, &data)
+	if !result {
+		return nil
+	}
+	// idx same,
+	if idx != data.IDX {
+		return nil
+	}
+	return &data
+}
+
+func DB_get_account_by_name(name string) *DBAccount {
+	idx, result := mredis.GetString("account_name_" + strings.ToLower(name))
+	if !result || len(idx) == 0 {
+		return nil
+	}
+	return DB_get_account(idx)
+}
+
+func DB_exists_account_name(name string) bool {
+	idx, result := mredis.GetString("account_name_" + strings.ToLower(name))
+	return result && len(idx) > 0
+}
+
+// New account only, false if idx exists
+func DB_create_account(account *DBAccount) bool {
+	if account == nil {
+		return false
+	}
+
+	account.TimeLast = util.DateFormat(time.Now(), 3)
+	return mredis.PushJsonNX[DBAccount]("account_"+account.IDX, account, util.TIME_KEEPN)
+}
+
+// Reserve name for idx, false if name exists
+func DB_create_account_name(name string, idx string) bool {
+	return mredis.PushStringNX("account_name_"+strings.ToLower(name), idx, util.TIME_KEEPN)
+}
+
+func DB_update_account(account *DBAccount) bool {
+	if account == nil {
+		return false
+	}
+
+	account.TimeLast = util.DateFormat(time.Now(), 3)
+
+	result := mredis.PushJson[DBAccount]("account_"+account.IDX, account, util.TIME_KEEPN)
+	if !result {
+		return false
+	}
+	return true
+}
+
+func DB_del_account(idx string) bool {
+	return mredis.DelWithKey("account_" + idx)
+}
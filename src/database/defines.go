@@ -14,7 +14,9 @@ type DBUserData struct {
 	ServerName      string `json:"server_name"`
 	ServerToken     string `json:"server_token"`
 	ServerUserToken string `json:"server_user_token"`
-	Status          int
+	// Trace ID of last HTTP auth, echoed by client in game auth (0x09)
+	TraceID string `json:"trace_id"`
+	Status  int
 }
 
 type DBUserKey struct {
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"strconv"
+	"strings"
+	"time"
+
+	mredis "mcmcx.com/mserver/modules/redis"
+	"mcmcx.com/mserver/src/util"
+)
+
+// (Redis) Game server identity, survives restarts
+type DBServerData struct {
+	ID        int    `json:"id"`
+	Key       string `json:"key"`        //config key: name or bind address
+	Token     string `json:"token"`      //server token
+	TokenPrev string `json:"token_prev"` //previous token, valid for a while after rotation
+	TokenTime int64  `json:"token_time"` //rotation time
+	Timestamp int64  `json:"timestamp"`  //create timestamp
+	TimeLast  string `json:"time_last"`  //(UPDATE AUTO)
+}
+
+// Server ID by config key
+func DB_get_server_id(key string) int {
+	value, result := mredis.GetNumber("gameserver_key_" + key)
+	if !result {
+		return 0
+	}
+	return int(value)
+}
+
+// New key only, false if key has an id
+func DB_create_server_id(key string, id int) bool {
+	return mredis.PushNumberNX("gameserver_key_"+key, int64(id), util.TIME_KEEPN)
+}
+
+// Reserve id for key, false if id is used by any key
+func DB_reserve_server_id(id int, key string) bool {
+	return mredis.PushStringNX("gameserver_id_"+strconv.Itoa(id), key, util.TIME_KEEPN)
+}
+
+// Key of reserved id, empty if not reserved
+func DB_get_server_id_key(id int) string {
+	key, _ := mredis.GetString("gameserver_id_" + strconv.Itoa(id))
+	return key
+}
+
+func DB_get_server_data(id int) *DBServerData {
+	var data DBServerData
+	result := mredis.GetJson[DBServerData]("gameserver_"+strconv.Itoa(id), &data)
+	if !result {
+		return nil
+	}
+	// id same,
+	if id != data.ID {
+		return nil
+	}
+	return &data
+}
+
+func DB_update_server_data(id int, server_data *DBServerData) bool {
+	if server_data == nil {
+		return false
+	}
+
+	server_data.TimeLast = util.DateFormat(time.Now(), 3)
+
+	result := mredis.PushJson[DBServerData]("gameserver_"+strconv.Itoa(id), server_data, util.TIME_KEEPN)
+	if !result {
+		return false
+	}
+	return true
+}
+
+// (Redis) Game server registry node, expired without heartbeat
+type DBServerNode struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	Title          string   `json:"title"`
+	Address        string   `json:"address"` //public (gate) address
+	Port           int      `json:"port"`
+	Token          string   `json:"token"`
+	SessionsNum    int32    `json:"sessions_num"`
+	SessionsMaxNum int32    `json:"sessions_maxnum"`
+	PriorityLevel  int      `json:"priority_level"`
+	Region         string   `json:"region"`
+	Tags           []string `json:"tags"`
+	Maintenance    bool     `json:"maintenance"`
+	Status         int      `json:"status"`
+	Timestamp      int64    `json:"timestamp"` //heartbeat timestamp
+}
+
+func DB_update_server_node(node *DBServerNode, keep float32) bool {
+	if node == nil {
+		return false
+	}
+
+	node.Timestamp = int64(util.GetTimeStamp64())
+
+	return mredis.PushJson[DBServerNode]("gameserver_node_"+strconv.Itoa(node.ID), node, keep)
+}
+
+func DB_del_server_node(id int) bool {
+	return mredis.DelWithKey("gameserver_node_" + strconv.Itoa(id))
+}
+
+func DB_get_server_nodes() []*DBServerNode {
+	keys, result := mredis.ScanKeys("gameserver_node_*")
+	if !result {
+		return nil
+	}
+
+	var nodes []*DBServerNode
+	for _, key := range keys {
+		var data DBServerNode
+		if !mredis.GetJson[DBServerNode](key, &data) || data.ID <= 0 {
+			continue
+		}
+		nodes = append(nodes, &data)
+	}
+	return nodes
+}
+
+// (Redis) Signing keys of login nodes, shared by login nodes
+// Readable by game nodes using the same Redis credentials (not a boundary),
+// ticket key of login node config file (ticket_key) preferred
+const (
+	DB_KEY_TICKET = "server_ticket_key" // server tickets (/auth)
+	DB_KEY_SIGN   = "server_sign_key"   // responses (level 2)
+)
+
+type DBTicketKey struct {
+	PKey      string `json:"pkey"` //ECC private key (x509)
+	Timestamp int64  `json:"timestamp"`
+}
+
+func DB_get_server_key(name string) *DBTicketKey {
+	var data DBTicketKey
+	result := mredis.GetJson[DBTicketKey](name, &data)
+	if !result || len(data.PKey) == 0 {
+		return nil
+	}
+	return &data
+}
+
+// New key only, false if key exists
+func DB_create_server_key(name string, server_key *DBTicketKey) bool {
+	if server_key == nil {
+		return false
+	}
+	return mredis.PushJsonNX[DBTicketKey](name, server_key, util.TIME_KEEPN)
+}
+
+// Key of name, created by first login node
+func DB_load_server_key(name string) *ecdsa.PrivateKey {
+	data := DB_get_server_key(name)
+	if data == nil {
+		skey, _, err := util.ECCGenkey()
+		if err != nil {
+			return nil
+		}
+		DB_create_server_key(name, &DBTicketKey{
+			PKey:      util.ECCX509PrivateKeyEncoding(skey),
+			Timestamp: int64(util.GetTimeStamp64()),
+		})
+		// Created by another login node first
+		data = DB_get_server_key(name)
+		if data == nil {
+			return nil
+		}
+	}
+	return util.ECCX509PrivateKeyDecoding(data.PKey)
+}
+
+// Public key of server tickets (hex), read by game servers
+func DB_get_ticket_public_key() string {
+	pkey, _ := mredis.GetString("server_ticket_pkey")
+	return pkey
+}
+
+func DB_update_ticket_public_key(pkey string) bool {
+	return mredis.PushString("server_ticket_pkey", pkey, util.TIME_KEEPN)
+}
+
+// Pub/sub channel: user logged out or disabled, game processes kick and refuse tickets
+// Message: idx timestamp64 (revoke time)
+const DB_CHANNEL_USER_REVOKE = "gameserver_user_revoke"
+
+func DB_publish_user_revoke(idx string, timestamp int64) bool {
+	return mredis.Publish(DB_CHANNEL_USER_REVOKE, idx+" "+strconv.FormatInt(timestamp, 10))
+}
+
+func DB_subscribe_user_revoke(handler func(idx string, timestamp int64), stop chan struct{}) bool {
+	return mredis.Subscribe(DB_CHANNEL_USER_REVOKE, func(message string) {
+		fields := strings.Fields(message)
+		if len(fields) != 2 {
+			return
+		}
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return
+		}
+		handler(fields[0], timestamp)
+	}, stop)
+}
@@ -0,0 +1,312 @@
+package gameserver
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/util"
+)
+
+// Load balancing strategies (config "balance")
+const (
+	BALANCE_DEFAULT  = "default"  // priority level, fill servers under 80%
+	BALANCE_LEAST    = "least"    // least connections (used ratio)
+	BALANCE_WEIGHTED = "weighted" // random, weighted by priority level
+	BALANCE_HASH     = "hash"     // consistent hashing by user idx (sticky)
+	BALANCE_AFFINITY = "affinity" // same region or tag, then least connections
+)
+
+// Virtual nodes per server in hash ring
+const BALANCE_HASH_REPLICAS = 64
+
+// Who is asking for a server
+type TBalanceRequest struct {
+	IDX    string
+	Region string
+	Tag    string
+}
+
+// Select from candidates (working, not full, not excluded), nil if empty
+type IBalance interface {
+	Name() string
+	Select(nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode
+}
+
+func NewBalance(name string) (IBalance, bool) {
+	switch name {
+	case "", BALANCE_DEFAULT:
+		return &t_balance_default{}, true
+	case BALANCE_LEAST:
+		return &t_balance_least{}, true
+	case BALANCE_WEIGHTED:
+		return &t_balance_weighted{}, true
+	case BALANCE_HASH:
+		return &t_balance_hash{}, true
+	case BALANCE_AFFINITY:
+		return &t_balance_affinity{}, true
+	}
+	return nil, false
+}
+
+// 0.0 - 1.0
+func node_used_ratio(node *database.DBServerNode) float32 {
+	if node.SessionsMaxNum <= 0 {
+		return 1.0
+	}
+	num := node.SessionsNum
+	if num < 0 {
+		num = 0
+	}
+	if num >= node.SessionsMaxNum {
+		num = node.SessionsMaxNum
+	}
+	return float32(num) / float32(node.SessionsMaxNum)
+}
+
+func node_full(node *database.DBServerNode) bool {
+	return node.SessionsNum >= node.SessionsMaxNum
+}
+
+// Working servers (not draining) not in maintenance and not full, exclude server by id (alternative for a full server)
+func filter_nodes(nodes []*database.DBServerNode, exclude int) []*database.DBServerNode {
+	var result []*database.DBServerNode
+	for _, v := range nodes {
+		if v == nil || v.Status != STATUS_WORKING || v.Maintenance || v.SessionsMaxNum <= 0 || node_full(v) {
+			continue
+		}
+		if exclude > 0 && v.ID == exclude {
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+func select_idle_node(balance IBalance, nodes []*database.DBServerNode, exclude int,
+	request *TBalanceRequest) *database.DBServerNode {
+	if balance == nil {
+		balance = &t_balance_default{}
+	}
+	if request == nil {
+		request = &TBalanceRequest{}
+	}
+	return balance.Select(filter_nodes(nodes, exclude), request)
+}
+
+//
+type t_balance_default struct{}
+
+func (self *t_balance_default) Name() string {
+	return BALANCE_DEFAULT
+}
+
+func (self *t_balance_default) Select(nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode {
+	var s1, s2, s3 *database.DBServerNode = nil, nil, nil
+	for _, v := range nodes {
+		// priority level
+		if s1 == nil || s1.PriorityLevel < v.PriorityLevel {
+			s1 = v
+		}
+
+		// percentage < 80, most used (fill)
+		percentage := node_used_ratio(v) * 100
+		if percentage < 80 && (s2 == nil || node_used_ratio(v) > node_used_ratio(s2)) {
+			s2 = v
+		}
+		// percentage >= 80, least used
+		if percentage >= 80 && (s3 == nil || node_used_ratio(v) < node_used_ratio(s3)) {
+			s3 = v
+		}
+	}
+
+	s := s1
+	if s == nil {
+		return nil
+	}
+	if s3 != nil && s3.PriorityLevel >= s.PriorityLevel {
+		s = s3
+	}
+	if s2 != nil && s2.PriorityLevel >= s.PriorityLevel {
+		s = s2
+	}
+	return s
+}
+
+//
+type t_balance_least struct{}
+
+func (self *t_balance_least) Name() string {
+	return BALANCE_LEAST
+}
+
+func (self *t_balance_least) Select(nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode {
+	var s *database.DBServerNode = nil
+	for _, v := range nodes {
+		if s == nil {
+			s = v
+			continue
+		}
+		ratio, s_ratio := node_used_ratio(v), node_used_ratio(s)
+		if ratio < s_ratio ||
+			(ratio == s_ratio && v.PriorityLevel > s.PriorityLevel) ||
+			(ratio == s_ratio && v.PriorityLevel == s.PriorityLevel && v.SessionsNum < s.SessionsNum) {
+			s = v
+		}
+	}
+	return s
+}
+
+// Weight: priority level + 1, full servers skipped
+type t_balance_weighted struct{}
+
+func (self *t_balance_weighted) Name() string {
+	return BALANCE_WEIGHTED
+}
+
+func (self *t_balance_weighted) Select(nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode {
+	var total = 0
+	for _, v := range nodes {
+		if !node_full(v) {
+			total += node_weight(v)
+		}
+	}
+	if total <= 0 {
+		return (&t_balance_least{}).Select(nodes, request)
+	}
+
+	value := int(util.RandomRange(0, int32(total)))
+	for _, v := range nodes {
+		if node_full(v) {
+			continue
+		}
+		value -= node_weight(v)
+		if value < 0 {
+			return v
+		}
+	}
+	return nil
+}
+
+func node_weight(node *database.DBServerNode) int {
+	if node.PriorityLevel < 0 {
+		return 1
+	}
+	return node.PriorityLevel + 1
+}
+
+// Same idx to same server while it exists, next server in ring when full
+// Ring rebuilt when the candidate servers change
+type t_balance_hash struct {
+	lock sync.Mutex
+	ids  string // sorted candidate ids of ring
+	ring []t_hash_point
+}
+
+type t_hash_point struct {
+	hash uint32
+	id   int
+}
+
+func (self *t_balance_hash) Name() string {
+	return BALANCE_HASH
+}
+
+func (self *t_balance_hash) Select(nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode {
+	if len(request.IDX) == 0 || len(nodes) == 0 {
+		return (&t_balance_least{}).Select(nodes, request)
+	}
+
+	var list = make(map[int]*database.DBServerNode, len(nodes))
+	for _, v := range nodes {
+		list[v.ID] = v
+	}
+
+	ring := self.get_ring(list)
+	hash := crc32.ChecksumIEEE([]byte(request.IDX))
+	start := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= hash
+	})
+	return list[ring[start%len(ring)].id]
+}
+
+func (self *t_balance_hash) get_ring(list map[int]*database.DBServerNode) []t_hash_point {
+	var ids = make([]int, 0, len(list))
+	for id := range list {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var key strings.Builder
+	for _, id := range ids {
+		key.WriteString(strconv.Itoa(id))
+		key.WriteByte(',')
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.ring != nil && self.ids == key.String() {
+		return self.ring
+	}
+
+	var ring = make([]t_hash_point, 0, len(ids)*BALANCE_HASH_REPLICAS)
+	for _, id := range ids {
+		for n := 0; n < BALANCE_HASH_REPLICAS; n++ {
+			point := strconv.Itoa(id) + "#" + strconv.Itoa(n)
+			ring = append(ring, t_hash_point{hash: crc32.ChecksumIEEE([]byte(point)), id: id})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash == ring[j].hash {
+			return ring[i].id < ring[j].id
+		}
+		return ring[i].hash < ring[j].hash
+	})
+
+	self.ids = key.String()
+	self.ring = ring
+	return ring
+}
+
+// Region first, then tag, then all; least connections in the group
+type t_balance_affinity struct{}
+
+func (self *t_balance_affinity) Name() string {
+	return BALANCE_AFFINITY
+}
+
+func (self *t_balance_affinity) Select(nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode {
+	var least = &t_balance_least{}
+
+	var region, tag []*database.DBServerNode
+	for _, v := range nodes {
+		if node_full(v) {
+			continue
+		}
+		if len(request.Region) > 0 && v.Region == request.Region {
+			region = append(region, v)
+		}
+		if len(request.Tag) > 0 && node_has_tag(v, request.Tag) {
+			tag = append(tag, v)
+		}
+	}
+
+	if len(region) > 0 {
+		return least.Select(region, request)
+	}
+	if len(tag) > 0 {
+		return least.Select(tag, request)
+	}
+	return least.Select(nodes, request)
+}
+
+func node_has_tag(node *database.DBServerNode, tag string) bool {
+	for _, v := range node.Tags {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package gameserver
+
+import (
+	"strconv"
+	"testing"
+
+	"mcmcx.com/mserver/src/database"
+)
+
+func test_node(id int, num int32, maxnum int32, priority int) *database.DBServerNode {
+	return &database.DBServerNode{
+		ID:             id,
+		SessionsNum:    num,
+		SessionsMaxNum: maxnum,
+		PriorityLevel:  priority,
+		Status:         STATUS_WORKING,
+	}
+}
+
+func test_select(t *testing.T, name string, nodes []*database.DBServerNode, request *TBalanceRequest) *database.DBServerNode {
+	balance, ok := NewBalance(name)
+	if !ok {
+		t.Fatalf("balance %q not found", name)
+	}
+	return select_idle_node(balance, nodes, 0, request)
+}
+
+func TestFilterNodes(t *testing.T) {
+	draining := test_node(2, 0, 100, 0)
+	draining.Status = STATUS_DRAINING
+	maintenance := test_node(3, 0, 100, 0)
+	maintenance.Maintenance = true
+	nodes := []*database.DBServerNode{
+		test_node(1, 10, 100, 0),
+		draining,
+		maintenance,
+		test_node(4, 100, 100, 0), // full
+		test_node(5, 0, 0, 0),     // no limit set
+		test_node(6, 10, 100, 0),
+	}
+
+	result := filter_nodes(nodes, 0)
+	if len(result) != 2 || result[0].ID != 1 || result[1].ID != 6 {
+		t.Fatalf("filter_nodes: got %v", test_ids(result))
+	}
+	result = filter_nodes(nodes, 6)
+	if len(result) != 1 || result[0].ID != 1 {
+		t.Fatalf("filter_nodes exclude: got %v", test_ids(result))
+	}
+}
+
+func TestBalanceFullSkipped(t *testing.T) {
+	for _, name := range []string{BALANCE_DEFAULT, BALANCE_LEAST, BALANCE_WEIGHTED, BALANCE_HASH, BALANCE_AFFINITY} {
+		nodes := []*database.DBServerNode{
+			test_node(1, 100, 100, 3), // full, highest priority
+			test_node(2, 50, 100, 0),
+		}
+		for n := 0; n < 20; n++ {
+			node := test_select(t, name, nodes, &TBalanceRequest{IDX: strconv.Itoa(1000000000 + n)})
+			if node == nil || node.ID != 2 {
+				t.Fatalf("%s: full server selected: %v", name, node)
+			}
+		}
+
+		all_full := []*database.DBServerNode{test_node(1, 100, 100, 0)}
+		if node := test_select(t, name, all_full, &TBalanceRequest{IDX: "1000000000"}); node != nil {
+			t.Fatalf("%s: all full, got %d", name, node.ID)
+		}
+	}
+}
+
+func TestBalanceDefault(t *testing.T) {
+	// Priority first, then fill the most used server under 80%
+	nodes := []*database.DBServerNode{
+		test_node(1, 10, 100, 0),
+		test_node(2, 50, 100, 1),
+		test_node(3, 70, 100, 1),
+		test_node(4, 90, 100, 1),
+	}
+	if node := test_select(t, BALANCE_DEFAULT, nodes, nil); node == nil || node.ID != 3 {
+		t.Fatalf("default: got %v", node)
+	}
+
+	// All >= 80%: least used
+	nodes = []*database.DBServerNode{
+		test_node(1, 95, 100, 0),
+		test_node(2, 85, 100, 0),
+	}
+	if node := test_select(t, BALANCE_DEFAULT, nodes, nil); node == nil || node.ID != 2 {
+		t.Fatalf("default over 80%%: got %v", node)
+	}
+}
+
+func TestBalanceLeast(t *testing.T) {
+	nodes := []*database.DBServerNode{
+		test_node(1, 50, 100, 0),
+		test_node(2, 10, 100, 0),
+		test_node(3, 10, 200, 0),
+	}
+	if node := test_select(t, BALANCE_LEAST, nodes, nil); node == nil || node.ID != 3 {
+		t.Fatalf("least: got %v", node)
+	}
+
+	// Same ratio: higher priority
+	nodes = []*database.DBServerNode{
+		test_node(1, 10, 100, 0),
+		test_node(2, 10, 100, 2),
+	}
+	if node := test_select(t, BALANCE_LEAST, nodes, nil); node == nil || node.ID != 2 {
+		t.Fatalf("least priority: got %v", node)
+	}
+}
+
+func TestBalanceWeighted(t *testing.T) {
+	nodes := []*database.DBServerNode{
+		test_node(1, 0, 100, 0), // weight 1
+		test_node(2, 0, 100, 3), // weight 4
+	}
+	var count = map[int]int{}
+	for n := 0; n < 5000; n++ {
+		node := test_select(t, BALANCE_WEIGHTED, nodes, nil)
+		if node == nil {
+			t.Fatal("weighted: nil")
+		}
+		count[node.ID]++
+	}
+	if count[1] == 0 || count[2] < count[1]*2 {
+		t.Fatalf("weighted: distribution %v", count)
+	}
+}
+
+func TestBalanceHash(t *testing.T) {
+	balance := &t_balance_hash{}
+	nodes := []*database.DBServerNode{
+		test_node(1, 0, 100, 0),
+		test_node(2, 0, 100, 0),
+		test_node(3, 0, 100, 0),
+	}
+	request := &TBalanceRequest{IDX: "1234567890"}
+
+	first := balance.Select(nodes, request)
+	if first == nil {
+		t.Fatal("hash: nil")
+	}
+	ring := balance.ring
+	for n := 0; n < 10; n++ {
+		if node := balance.Select(nodes, request); node.ID != first.ID {
+			t.Fatalf("hash: not sticky, %d != %d", node.ID, first.ID)
+		}
+	}
+	if &balance.ring[0] != &ring[0] {
+		t.Fatal("hash: ring rebuilt for same servers")
+	}
+
+	// Server removed (or full): moved to another server, ring rebuilt
+	var others []*database.DBServerNode
+	for _, v := range nodes {
+		if v.ID != first.ID {
+			others = append(others, v)
+		}
+	}
+	node := balance.Select(others, request)
+	if node == nil || node.ID == first.ID {
+		t.Fatalf("hash: removed server selected: %v", node)
+	}
+	if &balance.ring[0] == &ring[0] {
+		t.Fatal("hash: ring not rebuilt")
+	}
+
+	// Back: same server again
+	if node := balance.Select(nodes, request); node.ID != first.ID {
+		t.Fatalf("hash: not stable, %d != %d", node.ID, first.ID)
+	}
+
+	// Spread over servers
+	var count = map[int]int{}
+	for n := 0; n < 300; n++ {
+		count[balance.Select(nodes, &TBalanceRequest{IDX: strconv.Itoa(1000000000 + n)}).ID]++
+	}
+	if len(count) != len(nodes) {
+		t.Fatalf("hash: distribution %v", count)
+	}
+}
+
+func TestBalanceAffinity(t *testing.T) {
+	eu1 := test_node(1, 50, 100, 0)
+	eu1.Region = "eu"
+	eu2 := test_node(2, 20, 100, 0)
+	eu2.Region = "eu"
+	us := test_node(3, 0, 100, 0)
+	us.Region = "us"
+	us.Tags = []string{"pvp"}
+	nodes := []*database.DBServerNode{eu1, eu2, us}
+
+	if node := test_select(t, BALANCE_AFFINITY, nodes, &TBalanceRequest{Region: "eu"}); node == nil || node.ID != 2 {
+		t.Fatalf("affinity region: got %v", node)
+	}
+	if node := test_select(t, BALANCE_AFFINITY, nodes, &TBalanceRequest{Tag: "pvp"}); node == nil || node.ID != 3 {
+		t.Fatalf("affinity tag: got %v", node)
+	}
+	// Unknown region: least connections of all
+	if node := test_select(t, BALANCE_AFFINITY, nodes, &TBalanceRequest{Region: "asia"}); node == nil || node.ID != 3 {
+		t.Fatalf("affinity fallback: got %v", node)
+	}
+}
+
+func test_ids(nodes []*database.DBServerNode) []int {
+	var ids []int
+	for _, v := range nodes {
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
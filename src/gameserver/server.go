@@ -1,12 +1,22 @@
 package gameserver
 
 import (
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/modules/zinx/zpack"
+	"mcmcx.com/mserver/src/database"
 	"mcmcx.com/mserver/src/logout"
+	"mcmcx.com/mserver/src/util"
 )
 
+// Seconds, previous token valid after rotation
+const TOKEN_PREV_TIME = util.TIME_DAY
+
 //
 type i_server interface {
 	initialize() bool
@@ -20,17 +30,32 @@ type i_server interface {
 type t_server struct {
 	i_server
 
-	ID    int
-	Token string
+	ID int
+
+	// Current and previous token after rotation (rotated by admin, read by handlers)
+	token_lock sync.RWMutex
+	token      string
+	token_prev string
+	token_time int64
 
 	timestamp uint64
 
 	//
 	server ziface.IServer
 
-	//
-	priority_level int
-	status         int
+	// Written by reload, read by auth (atomic)
+	priority_level int32
+	status         int32
+
+	// Drain by reload, cancelled when added again
+	drain_lock   sync.Mutex
+	drain_cancel chan struct{}
+
+	// Admission, toggled at runtime
+	admission_lock      sync.RWMutex
+	maintenance         bool
+	maintenance_message string
+	whitelist           map[string]bool
 }
 
 func (self *t_server) SessionsMaxNum() int32 {
@@ -41,20 +66,208 @@ func (self *t_server) SessionsNum() int32 {
 	return int32(self.server.GetConnectionManager().Len())
 }
 
+// 0.0 - 1.0
 func (self *t_server) SessionsUsedRatio() float32 {
+	maxnum := self.SessionsMaxNum()
+	if maxnum <= 0 {
+		return 1.0
+	}
 	num := self.SessionsNum()
-	if num == 0 {
-		num = 1
+	if num >= maxnum {
+		num = maxnum
 	}
-	if num >= self.SessionsMaxNum() {
-		num = self.SessionsMaxNum()
+	return float32(num) / float32(maxnum)
+}
+
+// Registry node, public address
+func (self *t_server) node() *database.DBServerNode {
+	var node = &database.DBServerNode{
+		ID:             self.ID,
+		Token:          self.GetToken(),
+		Address:        "127.0.0.1",
+		Port:           9000,
+		SessionsNum:    self.SessionsNum(),
+		SessionsMaxNum: self.SessionsMaxNum(),
+		PriorityLevel:  self.get_priority_level(),
+		Status:         self.get_status(),
+	}
+	if info := GServerManager.GetServerInfo(self.ID); info != nil {
+		node.Name = info.Name
+		node.Title = info.Title
+		node.Address = info.GateAddress
+		node.Port = info.GatePort
+		node.Region = info.Region
+		node.Tags = info.Tags
+	}
+	node.Maintenance, _ = self.Maintenance()
+	return node
+}
+
+// Returns true if changed, connected players are notified
+func (self *t_server) set_maintenance(enable bool, message string) bool {
+	self.admission_lock.Lock()
+	changed := self.maintenance != enable || self.maintenance_message != message
+	self.maintenance = enable
+	self.maintenance_message = message
+	self.admission_lock.Unlock()
+
+	if changed && self.server != nil && self.working() {
+		self.notify_maintenance(enable, message)
+	}
+	return changed
+}
+
+func (self *t_server) set_whitelist(whitelist []string) {
+	var list = make(map[string]bool)
+	for _, v := range whitelist {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			list[v] = true
+		}
+	}
+
+	self.admission_lock.Lock()
+	self.whitelist = list
+	self.admission_lock.Unlock()
+}
+
+func (self *t_server) Maintenance() (bool, string) {
+	self.admission_lock.RLock()
+	defer self.admission_lock.RUnlock()
+	return self.maintenance, self.maintenance_message
+}
+
+// New auth allowed: not in maintenance, or idx in whitelist
+func (self *t_server) Admit(idx string) bool {
+	self.admission_lock.RLock()
+	defer self.admission_lock.RUnlock()
+	return !self.maintenance || self.whitelist[idx]
+}
+
+// Server Packet 03: Maintenance
+//   - Maintenance (int, 1: on, 0: off)
+//   - Server Timestamp (uint)
+//   - Message (string)
+func (self *t_server) notify_maintenance(enable bool, message string) {
+	var buffer zpack.MessageBuffer
+	if enable {
+		buffer.WriteInt32(1)
+	} else {
+		buffer.WriteInt32(0)
+	}
+	buffer.WriteUInt32(util.GetTimeStamp())
+	buffer.WriteStringL(message)
+
+	num := self.send_all(0x03, buffer.Data())
+	logout.LogWithName(LOG_GAMESERVER, "(Maintenance) Notice sessions, Server ID: ", self.ID,
+		", Maintenance: ", enable, ", Sessions: ", num)
+}
+
+// Server Packet 04: System Message
+//   - Server Timestamp (uint)
+//   - Message (string)
+func (self *t_server) broadcast(message string) int {
+	var buffer zpack.MessageBuffer
+	buffer.WriteUInt32(util.GetTimeStamp())
+	buffer.WriteStringL(message)
+
+	num := self.send_all(0x04, buffer.Data())
+	logout.LogWithName(LOG_GAMESERVER, "(Broadcast) System message, Server ID: ", self.ID,
+		", Sessions: ", num, ", Message: ", message)
+	return num
+}
+
+// Returns number of sessions sent
+func (self *t_server) send_all(id uint32, data []byte) int {
+	var num = 0
+	self.server.GetConnectionManager().Range(func(session ziface.IConnection) bool {
+		if session.SendBufferMsg(id, data) == nil {
+			num++
+		}
+		return true
+	})
+	return num
+}
+
+func (self *t_server) kick(sid uint32) bool {
+	session, err := self.server.GetConnectionManager().Get(sid)
+	if err != nil {
+		return false
+	}
+	logout.LogWithName(LOG_GAMESERVER, "(Kick) Session kicked", session_log_fields(session),
+		logout.F("server_id", self.ID), logout.F("address", session.RemoteAddr().String()))
+	session.Close()
+	return true
+}
+
+// Persisted token, survives restarts
+func (self *t_server) load_token(key string) bool {
+	data := database.DB_get_server_data(self.ID)
+	if data == nil {
+		data = &database.DBServerData{
+			ID:        self.ID,
+			Key:       key,
+			Token:     new_server_token(self.ID),
+			TokenTime: int64(util.GetTimeStamp64()),
+			Timestamp: int64(util.GetTimeStamp64()),
+		}
+		if !database.DB_update_server_data(self.ID, data) {
+			return false
+		}
+	}
+
+	self.set_token(data)
+	return true
+}
+
+func (self *t_server) rotate_token() bool {
+	data := database.DB_get_server_data(self.ID)
+	if data == nil {
+		return false
+	}
+
+	data.TokenPrev = data.Token
+	data.Token = new_server_token(self.ID)
+	data.TokenTime = int64(util.GetTimeStamp64())
+	if !database.DB_update_server_data(self.ID, data) {
+		return false
 	}
 
-	percentage := float32(num) / float32(self.SessionsMaxNum())
-	if percentage < 1.0 {
-		percentage = 1.0
+	self.set_token(data)
+	return true
+}
+
+func (self *t_server) set_token(data *database.DBServerData) {
+	self.token_lock.Lock()
+	self.token = data.Token
+	self.token_prev = data.TokenPrev
+	self.token_time = data.TokenTime
+	self.token_lock.Unlock()
+}
+
+func (self *t_server) GetToken() string {
+	self.token_lock.RLock()
+	defer self.token_lock.RUnlock()
+	return self.token
+}
+
+// Current token, or previous token not expired
+func (self *t_server) CheckToken(token string) bool {
+	if len(token) == 0 {
+		return false
+	}
+	self.token_lock.RLock()
+	defer self.token_lock.RUnlock()
+	if token == self.token {
+		return true
 	}
-	return percentage
+	return token == self.token_prev &&
+		util.ExpiredTimestamp64(uint64(self.token_time), TOKEN_PREV_TIME) > 0
+}
+
+func new_server_token(id int) string {
+	var code = util.GenerateAuthCode(4)
+	return util.MD5(strconv.FormatInt(int64(id), 10) + "_" + code)
 }
 
 func (self *t_server) initialize() bool {
@@ -63,6 +276,8 @@ func (self *t_server) initialize() bool {
 	self.server.SetDataPtr(self)
 	self.server.SetOnConnectionStart(handler_session_accept)
 	self.server.SetOnConnectionStop(handler_session_closed)
+	self.server.SetOnConnectionFull(handler_session_full)
+	self.server.SetOnConnectionRefused(handler_session_refused)
 
 	//
 	self.server.AddRouter(0x00, &HandlerHello{})
@@ -79,12 +294,42 @@ func (self *t_server) release() {
 }
 
 func (self *t_server) working() bool {
-	return self.status >= STATUS_WORKING
+	return self.get_status() >= STATUS_WORKING
+}
+
+// Back to working, false if not draining or already being freed
+func (self *t_server) cancel_drain() bool {
+	self.drain_lock.Lock()
+	defer self.drain_lock.Unlock()
+	if self.drain_cancel == nil {
+		return false
+	}
+	close(self.drain_cancel)
+	self.drain_cancel = nil
+	self.set_status(STATUS_WORKING)
+	return true
+}
+
+func (self *t_server) get_status() int {
+	return int(atomic.LoadInt32(&self.status))
+}
+
+func (self *t_server) set_status(status int) {
+	atomic.StoreInt32(&self.status, int32(status))
+}
+
+func (self *t_server) get_priority_level() int {
+	return int(atomic.LoadInt32(&self.priority_level))
+}
+
+func (self *t_server) set_priority_level(level int) {
+	atomic.StoreInt32(&self.priority_level, int32(level))
 }
 
 func (self *t_server) on_session_accept(session ziface.IConnection) {
-	logout.LogWithName(LOG_GAMESERVER, "(Accept) Session accept ID: ", session.GetConnectionID(),
-		", Address: ", session.RemoteAddr())
+	logout.LogWithName(LOG_GAMESERVER, "(Accept) Session accept",
+		logout.F("sid", session.GetConnectionID()), logout.F("server_id", self.ID),
+		logout.F("address", session.RemoteAddr().String()))
 
 	//Add temp user
 	user := &TTempUser{}
@@ -93,10 +338,10 @@ func (self *t_server) on_session_accept(session ziface.IConnection) {
 		return
 	}
 
-	address := "127.0.0.1"
-	v := strings.Split(session.RemoteAddr().String(), ":")
-	if len(v) > 0 {
-		address = v[0]
+	// Real client address (PROXY protocol from gate)
+	address, _, err := net.SplitHostPort(session.RemoteAddr().String())
+	if err != nil {
+		address = "127.0.0.1"
 	}
 
 	if !user.Load(session.GetConnectionID(), address) {
@@ -121,8 +366,61 @@ func (self *t_server) on_session_closed(session ziface.IConnection) {
 	}
 
 	//
-	logout.LogWithName(LOG_GAMESERVER, "(Close) Session closed ID: ", session.GetConnectionID(),
-		", Address: ", session.RemoteAddr())
+	logout.LogWithName(LOG_GAMESERVER, "(Close) Session closed", session_log_fields(session),
+		logout.F("server_id", self.ID), logout.F("address", session.RemoteAddr().String()))
+}
+
+// Session fields for logs: sid, trace id (after game auth)
+func session_log_fields(session ziface.IConnection) []logout.LogField {
+	if session == nil {
+		return nil
+	}
+	fields := []logout.LogField{logout.F("sid", session.GetConnectionID())}
+	if value, err := session.GetProperty("trace_id"); err == nil {
+		if trace_id, _ := value.(string); len(trace_id) > 0 {
+			fields = append(fields, logout.F("trace_id", trace_id))
+		}
+	}
+	return fields
+}
+
+// Trace ID of session, also in zinx logs of the connection
+func session_set_trace_id(session ziface.IConnection, trace_id string) {
+	session.SetProperty("trace_id", trace_id)
+	if len(trace_id) > 0 {
+		session.SetLogTag("trace_id = " + trace_id)
+	}
+}
+
+// Server Packet 02: Full
+//   - Result (int, -1)
+//   - Server Timestamp (uint)
+//   - Alternative Server ID (int, 0: none)
+//   - Alternative Server Name (string)
+//   - Alternative Server Address (string)
+//   - Alternative Server Port (int)
+func (self *t_server) on_session_full() (uint32, []byte) {
+	var buffer zpack.MessageBuffer
+	buffer.WriteInt32(-1)
+	buffer.WriteUInt32(util.GetTimeStamp())
+
+	var alt_id, alt_port int32 = 0, 0
+	var alt_name, alt_address = "", ""
+	alt := GServerManager.get_idle_node(self.ID, nil)
+	if alt != nil {
+		alt_id = int32(alt.ID)
+		alt_name = alt.Title
+		alt_address = alt.Address
+		alt_port = int32(alt.Port)
+	}
+	buffer.WriteInt32(alt_id)
+	buffer.WriteStringL(alt_name)
+	buffer.WriteStringL(alt_address)
+	buffer.WriteInt32(alt_port)
+
+	logout.LogWithName(LOG_GAMESERVER, "(Full) Session rejected, Server ID: ", self.ID,
+		", Sessions: ", self.SessionsNum(), "/", self.SessionsMaxNum(), ", Alternative: ", alt_id)
+	return 0x02, buffer.Data()
 }
 
 func handler_session_accept(data any, session ziface.IConnection) {
@@ -133,7 +431,7 @@ func handler_session_accept(data any, session ziface.IConnection) {
 	}
 
 	session.SetProperty("server_id", server.ID)
-	session.SetProperty("server_token", server.Token)
+	session.SetProperty("server_token", server.GetToken())
 	server.on_session_accept(session)
 }
 
@@ -145,3 +443,22 @@ func handler_session_closed(data any, session ziface.IConnection) {
 	}
 	server.on_session_closed(session)
 }
+
+func handler_session_full(data any) (uint32, []byte) {
+	server, ok := data.(*t_server)
+	if !ok || server == nil {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Session full failed")
+		return 0x02, nil
+	}
+	return server.on_session_full()
+}
+
+func handler_session_refused(data any, address net.Addr, reason string) {
+	server, ok := data.(*t_server)
+	if !ok || server == nil {
+		logout.LogWithName(LOG_GAMESERVER, "(Refused) Session refused, Address: ", address, ", Reason: ", reason)
+		return
+	}
+	logout.LogWithName(LOG_GAMESERVER, "(Refused) Session refused, Server ID: ", server.ID,
+		", Address: ", address, ", Reason: ", reason)
+}
@@ -7,18 +7,18 @@ import (
 	"mcmcx.com/mserver/modules/zinx/ziface"
 	"mcmcx.com/mserver/modules/zinx/znet"
 	"mcmcx.com/mserver/modules/zinx/zpack"
-	"mcmcx.com/mserver/src/database"
 	"mcmcx.com/mserver/src/logout"
 	"mcmcx.com/mserver/src/util"
 )
 
-//
+// Per request (local of Handle), routers are shared by all sessions
 type HandlerBase struct {
 	ServerID    int
 	ServerToken string
 
 	//
 	LogName string
+	TraceID string // from session property, set after game auth
 
 	//
 	SessionID     int
@@ -42,6 +42,11 @@ func (self *HandlerBase) InitHandle(request ziface.IRequest) bool {
 	user_id, _ := self.Session.GetProperty("user_id")
 	user_type, _ := self.Session.GetProperty("user_type")
 
+	self.TraceID = ""
+	if trace_id, err := self.Session.GetProperty("trace_id"); err == nil {
+		self.TraceID, _ = trace_id.(string)
+	}
+
 	self.SessionUserID = user_id.(int)
 	self.SessionUser = nil
 	if user_type.(string) == USER_NORMAL {
@@ -56,41 +61,65 @@ func (self *HandlerBase) InitHandle(request ziface.IRequest) bool {
 	}
 
 	if self.SessionUser == nil {
-		logout.LogWithName(self.LogName, "[ERROR] (User) Session user NULL",
-			", ID:", user_id, " (", user_type, ")", ", SID:", self.SessionID)
+		self.Log("[ERROR] (User) Session user NULL", logout.F("user_type", user_type))
 		return false
 	}
 
 	return true
 }
 
+// Session fields for structured logs (trace id of session, or of auth request)
+func (self *HandlerBase) LogFields() []logout.LogField {
+	fields := []logout.LogField{
+		logout.F("id", self.SessionUserID),
+		logout.F("sid", self.SessionID),
+		logout.F("server_id", self.ServerID),
+	}
+	if len(self.TraceID) > 0 {
+		fields = append(fields, logout.F("trace_id", self.TraceID))
+	}
+	return fields
+}
+
+// Log of session, fields of session (LogFields) always added after message
+func (self *HandlerBase) Log(message string, fields ...logout.LogField) {
+	logout.LogWithName(self.LogName, message, self.LogFields(), fields)
+}
+
+func (self *HandlerBase) SendBufferMsg(id uint32, data []byte) bool {
+	err := self.Session.SendBufferMsg(id, data)
+	if err != nil {
+		self.Log("[ERROR] (User) Send message failed", logout.F("msg_id", id),
+			logout.F("dropped", self.Session.DroppedMsgNum()), logout.F("error", err.Error()))
+		return false
+	}
+	return true
+}
+
 //
 type HandlerHello struct {
 	znet.BaseRouter
-	super HandlerBase
 }
 
 //
 type HandlerPing struct {
 	znet.BaseRouter
-	super HandlerBase
 }
 
 //
 type HandlerAuth struct {
 	znet.BaseRouter
-	super HandlerBase
 }
 
 //
 type HandlerUser struct {
 	znet.BaseRouter
-	super HandlerBase
 }
 
 // Handler 00: Hello
 func (self *HandlerHello) Handle(request ziface.IRequest) {
-	if !self.super.InitHandle(request) {
+	var super HandlerBase
+	if !super.InitHandle(request) {
 		return
 	}
 
@@ -99,15 +128,13 @@ func (self *HandlerHello) Handle(request ziface.IRequest) {
 	buffer.WriteUInt64(util.GetTimeStamp64())
 	buffer.WriteStringL(util.DateFormat(time.Now(), 3))
 
-	err := self.super.Session.SendBufferMsg(0, buffer.Data())
-	if err != nil {
-		return
-	}
+	super.SendBufferMsg(0, buffer.Data())
 }
 
 // Handler 01: Ping
 func (self *HandlerPing) Handle(request ziface.IRequest) {
-	if !self.super.InitHandle(request) {
+	var super HandlerBase
+	if !super.InitHandle(request) {
 		return
 	}
 
@@ -115,15 +142,18 @@ func (self *HandlerPing) Handle(request ziface.IRequest) {
 	buffer.WriteUInt32(util.GetTimeStamp())
 	buffer.WriteUInt64(util.GetTimeStamp64())
 
-	err := self.super.Session.SendBufferMsg(1, buffer.Data())
-	if err != nil {
-		return
-	}
+	super.SendBufferMsg(1, buffer.Data())
 }
 
 //
-func (self *HandlerAuth) ServerAuth(id int, token string, info *TPServerInfo) int {
-	if id != self.super.ServerID || token != self.super.ServerToken {
+func (self *HandlerAuth) ServerAuth(super *HandlerBase, id int, token string, info *TPServerInfo) int {
+	if id != super.ServerID {
+		return -1
+	}
+
+	// Current or previous token (rotation)
+	server := GServerManager.GetServer(id)
+	if server == nil || !server.CheckToken(token) {
 		return -1
 	}
 
@@ -137,35 +167,7 @@ func (self *HandlerAuth) ServerAuth(id int, token string, info *TPServerInfo) in
 }
 
 //
-func (self *HandlerAuth) DBUserAuth(idx string, token string, key **database.DBUserKey) int {
-	// Get user data
-	var db_user_data *database.DBUserData = database.DB_get_user_data(idx)
-	if db_user_data == nil || db_user_data.Status < 0 {
-		return -1
-	}
-
-	if db_user_data.IDX != idx || db_user_data.ServerUserToken != token {
-		return -1
-	}
-
-	if key == nil {
-		return -1
-	}
-
-	*key = &database.DBUserKey{
-		IDX:      db_user_data.IDX,
-		PKey:     db_user_data.PKey,
-		PKeyHash: db_user_data.PKeyHash,
-	}
-	if *key != nil {
-		return 1 // OK
-	}
-
-	return 0 // FAILED
-}
-
-//
-func (self *HandlerAuth) UserLoad(user *TUser, idx string, token string,
+func (self *HandlerAuth) UserLoad(super *HandlerBase, user *TUser, idx string, token string,
 	timestamp uint32, server_id int, server_token string, server_info TPServerInfo,
 	user_addr string, shared_key string) int {
 	//
@@ -173,7 +175,7 @@ func (self *HandlerAuth) UserLoad(user *TUser, idx string, token string,
 		return -1
 	}
 
-	if !user.Load(self.super.Session.GetConnectionID(), user_addr) {
+	if !user.Load(super.Session.GetConnectionID(), user_addr) {
 		return -1
 	}
 
@@ -203,18 +205,22 @@ func (self *HandlerAuth) UserLoad(user *TUser, idx string, token string,
 //   - User Timestamp (uint client)
 //   - Server ID (int)
 //   - Server Token (MD5 16bytes)
-//   - User Remote Address (string)
-//   - User Authentication Token (MD5 string)
+//   - User Remote Address (string, ignored)
+//   - User Ticket (signed string, from HTTP auth server_user_token)
 //   - User PublicKey (ECC bytes)
+//   - Trace ID (string, from HTTP auth, optional)
 // Server Packet:
-//   - Result (int)
+//   - Result (int, -2: maintenance)
 //   - User Timestamp (uint server)
 //   - User IDX (string, result >= 0)
 //   - Server ID (int, result >= 1)
 //   - Server Name (string, result >= 1)
+//   - Trace ID (string, result >= 1)
+//   - Server PublicKey (ECC bytes, result >= 1, session key: shared key with User PublicKey)
 
 func (self *HandlerAuth) Handle(request ziface.IRequest) {
-	if !self.super.InitHandle(request) {
+	var super HandlerBase
+	if !super.InitHandle(request) {
 		return
 	}
 
@@ -222,68 +228,97 @@ func (self *HandlerAuth) Handle(request ziface.IRequest) {
 	// User IDX
 	idx := recv_buffer.ReadStringL()
 	timestamp := recv_buffer.ReadUInt32()
+	server_id := recv_buffer.ReadInt32()
+	server_token := strings.TrimSpace(recv_buffer.ReadStringL())
+	// User address (client field is not trusted, gate sends PROXY header)
+	_ = recv_buffer.ReadStringL()
+	user_token := strings.TrimSpace(recv_buffer.ReadStringL())
+	user_pkey_data := recv_buffer.ReadBytesL()
+	trace_id := strings.ToLower(strings.TrimSpace(recv_buffer.ReadStringL()))
+	if util.CheckTraceID(trace_id) {
+		super.TraceID = trace_id
+	}
+
 	if len(idx) == 0 || timestamp == 0 {
-		logout.LogWithName(self.super.LogName, "[AUTH] (User) Authentication failed, Result: idx error",
-			", ID:", self.super.SessionUserID, ", SID:", self.super.SessionID)
+		super.Log("[AUTH] (User) Authentication failed",
+			logout.F("result", "idx error"))
 
-		self.HandleResultFailed(request, -1)
+		self.HandleResultFailed(&super, -1)
 		return
 	}
 	idx = strings.TrimSpace(idx)
 
 	// Server Auth
-	server_id := recv_buffer.ReadInt32()
-	server_token := strings.TrimSpace(recv_buffer.ReadStringL())
 	var server_info TPServerInfo = nil
-	if self.ServerAuth(int(server_id), server_token, &server_info) <= 0 {
-		logout.LogWithName(self.super.LogName, "[AUTH] (User) Authentication failed, Result: server error",
-			", ID:", self.super.SessionUserID, ", SID:", self.super.SessionID)
+	if self.ServerAuth(&super, int(server_id), server_token, &server_info) <= 0 {
+		super.Log("[AUTH] (User) Authentication failed",
+			logout.F("result", "server error"))
 
-		self.HandleResultFailed(request, -1)
+		self.HandleResultFailed(&super, -1)
 		return
 	}
 
-	// User address
-	user_addr := strings.TrimSpace(recv_buffer.ReadStringL())
-	if len(user_addr) == 0 {
-		v := strings.Split(self.super.Session.RemoteAddr().String(), ":")
-		if len(v) > 0 {
-			user_addr = v[0]
-		}
+	// Maintenance, whitelist only
+	if server := GServerManager.GetServer(int(server_id)); server == nil || !server.Admit(idx) {
+		super.Log("[AUTH] (User) Authentication failed",
+			logout.F("result", "maintenance"), logout.F("idx", idx))
+
+		self.HandleResultFailed(&super, -2)
+		return
 	}
-	// Not gate address, use remote address
-	if self.super.SessionUser.RemoteAddress() != server_info.GateAddress &&
-		self.super.SessionUser.RemoteAddress() != user_addr {
-		user_addr = self.super.SessionUser.RemoteAddress()
+
+	user_addr := super.SessionUser.RemoteAddress()
+
+	// User Ticket (IDX, server, expires and trace id of claims), no user data
+	ticket, ticket_result := verify_ticket(user_token, idx, int(server_id))
+	if ticket_result <= 0 {
+		var reason = "ticket failed"
+		switch ticket_result {
+		case -1:
+			reason = "ticket expired"
+		case -2:
+			reason = "ticket reused"
+		case -3:
+			reason = "ticket error"
+		case -4:
+			reason = "ticket revoked"
+		}
+		super.Log("[AUTH] (User) Authentication failed",
+			logout.F("result", reason), logout.F("idx", idx))
+
+		self.HandleResultFailedEx(&super, 0, idx)
+		return
 	}
 
-	// User Token
-	user_token := strings.TrimSpace(recv_buffer.ReadStringL())
+	// Trace ID of HTTP auth (ticket), client value only logged if different
+	if len(ticket.TraceID) > 0 {
+		if len(trace_id) > 0 && trace_id != ticket.TraceID {
+			super.Log("[AUTH] (User) Trace ID mismatch",
+				logout.F("idx", idx), logout.F("trace_id_ticket", ticket.TraceID))
+		}
+		super.TraceID = ticket.TraceID
+	}
 
-	var user_key *database.DBUserKey
-	if self.DBUserAuth(idx, user_token, &user_key) <= 0 {
-		logout.LogWithName(self.super.LogName, "[AUTH] (User) Authentication failed, Result: failed",
-			", ID:", self.super.SessionUserID, ", SID:", self.super.SessionID, ", IDX:", idx)
+	// Session key of server, shared key with user key
+	server_skey, _, err := util.ECCGenkey()
+	if err != nil {
+		super.Log("[AUTH] (User) Authentication failed",
+			logout.F("result", "key error"), logout.F("idx", idx))
 
-		self.HandleResultFailedEx(request, 0, idx)
+		self.HandleResultFailedEx(&super, 0, idx)
 		return
 	}
-	var user_skey = util.ECCX509PrivateKeyDecoding(user_key.PKey)
-
-	// User Key
 	user_shared_key := ""
-	user_pkey_data := recv_buffer.ReadBytesL()
 	if user_pkey_data != nil {
-		user_pkey := util.ECCPublicKeyParseData(user_pkey_data)
-		if user_pkey != nil && user_skey != nil {
-			user_shared_key = util.ECCGenSharedKeyEncoding(user_skey, user_pkey)
+		if user_pkey := util.ECCPublicKeyParseData(user_pkey_data); user_pkey != nil {
+			user_shared_key = util.ECCGenSharedKeyEncoding(server_skey, user_pkey)
 		}
 	}
 
 	// User load
 	var user *TUser = &TUser{}
 	result := GUserManager.AddUser(user)
-	if result && self.UserLoad(user, idx, user_token, timestamp,
+	if result && self.UserLoad(&super, user, idx, user_token, timestamp,
 		int(server_id), server_token, server_info,
 		user_addr, user_shared_key) > 0 {
 		result = true
@@ -295,49 +330,45 @@ func (self *HandlerAuth) Handle(request ziface.IRequest) {
 
 	// SUCCESSED
 	if result {
-		self.super.Session.SetProperty("user_id", user.ID())
-		self.super.Session.SetProperty("user_type", user.Type())
-		GTempUserManager.DelUserByID(self.super.SessionUserID)
+		user.TraceID = super.TraceID
+		super.Session.SetProperty("user_id", user.ID())
+		super.Session.SetProperty("user_type", user.Type())
+		session_set_trace_id(super.Session, user.TraceID)
+		GTempUserManager.DelUserByID(super.SessionUserID)
 
-		logout.LogWithName(self.super.LogName, "[AUTH] (User) Authentication successed, Result: ok",
-			", ID:", self.super.SessionUserID, ", SID:", self.super.SessionID,
-			", IDX:", idx, ", NewID:", user.ID(), ", Address:", user.RemoteAddress())
+		super.Log("[AUTH] (User) Authentication successed",
+			logout.F("result", "ok"), logout.F("idx", idx),
+			logout.F("new_id", user.ID()), logout.F("address", user.RemoteAddress()))
 
-		self.HandleResultSuccessed(request, 1, user)
+		self.HandleResultSuccessed(&super, 1, user, util.ECCPublicKeyData(&server_skey.PublicKey))
 		return
 	}
 
-	logout.LogWithName(self.super.LogName, "[AUTH] (User) Authentication failed, Result: failed",
-		", ID:", self.super.SessionUserID, ", SID:", self.super.SessionID, ", IDX:", idx)
+	super.Log("[AUTH] (User) Authentication failed",
+		logout.F("result", "failed"), logout.F("idx", idx))
 
 	//
-	self.HandleResultFailedEx(request, 0, idx)
+	self.HandleResultFailedEx(&super, 0, idx)
 }
 
-func (self *HandlerAuth) HandleResultFailed(request ziface.IRequest, result int32) {
+func (self *HandlerAuth) HandleResultFailed(super *HandlerBase, result int32) {
 	var buffer zpack.MessageBuffer
 	buffer.WriteInt32(result)
 	buffer.WriteUInt32(util.GetTimeStamp())
 
-	err := self.super.Session.SendBufferMsg(0x09, buffer.Data())
-	if err != nil {
-		return
-	}
+	super.SendBufferMsg(0x09, buffer.Data())
 }
 
-func (self *HandlerAuth) HandleResultFailedEx(request ziface.IRequest, result int32, idx string) {
+func (self *HandlerAuth) HandleResultFailedEx(super *HandlerBase, result int32, idx string) {
 	var buffer zpack.MessageBuffer
 	buffer.WriteInt32(result)
 	buffer.WriteUInt32(util.GetTimeStamp())
 	buffer.WriteStringL(idx)
 
-	err := self.super.Session.SendBufferMsg(0x09, buffer.Data())
-	if err != nil {
-		return
-	}
+	super.SendBufferMsg(0x09, buffer.Data())
 }
 
-func (self *HandlerAuth) HandleResultSuccessed(request ziface.IRequest, result int32, user *TUser) {
+func (self *HandlerAuth) HandleResultSuccessed(super *HandlerBase, result int32, user *TUser, server_pkey []byte) {
 	var buffer zpack.MessageBuffer
 	buffer.WriteInt32(result)
 	buffer.WriteUInt32(user.ServerTimestamp32)
@@ -346,16 +377,16 @@ func (self *HandlerAuth) HandleResultSuccessed(request ziface.IRequest, result i
 	// Result >= 1
 	buffer.WriteInt32(int32(user.ServerID))
 	buffer.WriteStringL(user.ServerName)
+	buffer.WriteStringL(user.TraceID)
+	buffer.WriteBytesL(server_pkey)
 
-	err := self.super.Session.SendBufferMsg(0x09, buffer.Data())
-	if err != nil {
-		return
-	}
+	super.SendBufferMsg(0x09, buffer.Data())
 }
 
 // Handler 10: User
 func (self *HandlerUser) Handle(request ziface.IRequest) {
-	if !self.super.InitHandle(request) {
+	var super HandlerBase
+	if !super.InitHandle(request) {
 		return
 	}
 
@@ -363,23 +394,20 @@ func (self *HandlerUser) Handle(request ziface.IRequest) {
 	// User IDX
 	idx := recv_buffer.ReadStringL()
 	idx = strings.TrimSpace(idx)
-	if len(idx) == 0 || self.super.SessionUser == nil {
+	if len(idx) == 0 || super.SessionUser == nil {
 		return
 	}
-	user := self.super.SessionUser.(*TUser)
+	user := super.SessionUser.(*TUser)
 	if user.IDX != idx {
 		return
 	}
 
-	self.HandleResultUser(request, user)
+	self.HandleResultUser(&super, user)
 }
 
-func (self *HandlerUser) HandleResultUser(request ziface.IRequest, user *TUser) {
+func (self *HandlerUser) HandleResultUser(super *HandlerBase, user *TUser) {
 	var buffer zpack.MessageBuffer
 	buffer.WriteStringL(user.IDX)
 
-	err := self.super.Session.SendBufferMsg(0x10, buffer.Data())
-	if err != nil {
-		return
-	}
+	super.SendBufferMsg(0x10, buffer.Data())
 }
@@ -2,25 +2,42 @@ package gameserver
 
 import (
 	"fmt"
-	"strconv"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/modules/zinx/zlog"
 	"mcmcx.com/mserver/modules/zinx/znet"
 	"mcmcx.com/mserver/modules/zinx/zutils"
+	"mcmcx.com/mserver/src/database"
 	"mcmcx.com/mserver/src/logout"
 	"mcmcx.com/mserver/src/util"
 )
 
 //
 const LOG_GAMESERVER = "GAMESERVER"
+const LOG_ZINX = "ZINX" // zinx zlog and connection diagnostics
 
 const (
-	STATUS_FREE    = -1
-	STATUS_NULL    = 0
-	STATUS_INIT    = 1
-	STATUS_WORKING = 2
+	STATUS_FREE     = -1
+	STATUS_NULL     = 0
+	STATUS_INIT     = 1
+	STATUS_WORKING  = 2
+	STATUS_DRAINING = 3 // Removed from config, waiting sessions closed
 )
 
+// Seconds
+const DRAIN_TIMEOUT = 300
+
+// Attempts of generated server id
+const SERVER_ID_RETRY = 10
+
+// Seconds, registry heartbeat (node expired after 3 intervals)
+const REGISTRY_INTERVAL = 5
+
 const (
 	PRIORITY_NORMAL = 0
 	PRIORITY_LEVEL1 = 1
@@ -28,9 +45,16 @@ const (
 	PRIORITY_LEVEL3 = 3
 )
 
+// Listen address, unix type address is socket file path
+type TServerListenInfo struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
 //
 type TServerInfo struct {
-	ID      int
+	ID      int    `json:"id"` // 0: persisted in redis by name or bind address
 	Name    string `json:"name"`
 	Title   string `json:"title"`
 	Version string `json:"version"`
@@ -39,29 +63,82 @@ type TServerInfo struct {
 	// Bind Address
 	Address string `json:"address"`
 	Port    int    `json:"port"`
+	// Bind more addresses (tcp4, tcp6, unix), sharing sessions limit
+	Listeners []TServerListenInfo `json:"listeners"`
 	// Public Address
 	GateAddress string `json:"gate_address"`
 	GatePort    int    `json:"gate_port"`
 
-	PacketSize        int `json:"packet_size"`
-	ConnectionsMaxNum int `json:"connections_maxnum"`
+	PacketSize        int    `json:"packet_size"`
+	ConnectionsMaxNum int    `json:"connections_maxnum"`
+	FullMode          string `json:"full_mode"` // wait, reject
+
+	// IP filter file (allow, deny, bans), max connections per IP
+	IPFilter            string `json:"ip_filter"`
+	IPConnectionsMaxNum int    `json:"ip_connections_maxnum"`
+
+	// PROXY protocol from trusted gate addresses (default: gate address)
+	ProxyProtocol bool     `json:"proxy_protocol"`
+	ProxyTrusted  []string `json:"proxy_trusted"`
+
+	// Send buffer: timeout (ms), policy (drop, block, disconnect, backlog)
+	SendTimeout       int    `json:"send_timeout"`
+	SendPolicy        string `json:"send_policy"`
+	SendBacklogMaxNum int    `json:"send_backlog_maxnum"`
 
 	//
 	PriorityLevel int `json:"priority_level"`
+	// Affinity balancing
+	Region string   `json:"region"`
+	Tags   []string `json:"tags"`
+	// Seconds, waiting sessions closed when removed by reload
+	DrainTimeout int `json:"drain_timeout"`
+
+	// Maintenance: not assigned, new auth refused except whitelist (IDX)
+	Maintenance        bool     `json:"maintenance"`
+	MaintenanceMessage string   `json:"maintenance_message"`
+	Whitelist          []string `json:"whitelist"`
 }
 
 type TServerInfoList struct {
+	// Register servers in redis, shared by login nodes
+	Registry         bool `json:"registry"`
+	RegistryInterval int  `json:"registry_interval"`
+	// Load balancing strategy (default, least, weighted, hash, affinity)
+	Balance string `json:"balance"`
+	// Public key of tickets (hex), empty: published by login nodes in Redis
+	TicketPKey string `json:"ticket_pkey"`
+
 	List []TServerInfo `json:"list"`
 }
 type TPServerInfo *TServerInfo
 
 //
 type ServerManager struct {
+	filename string
+
+	//
+	info_lock    sync.RWMutex
 	servers_info map[int]TPServerInfo
 
 	//
 	servers_lock sync.Mutex
 	servers_list map[int]*t_server
+
+	//
+	registry          bool
+	registry_interval int
+	registry_stop     chan struct{}
+
+	// Pub/sub of revoked users (logout on login nodes)
+	revoke_stop chan struct{}
+
+	// Changed by reload
+	balance_lock sync.RWMutex
+	balance      IBalance
+
+	// Set at load, restart to change
+	ticket_pkey string
 }
 
 var GServerManager ServerManager
@@ -75,32 +152,312 @@ func (self *ServerManager) initialize() bool {
 }
 
 //
-func (self *ServerManager) load_serverinfo(filename string) bool {
-	self.servers_info = make(map[int]TPServerInfo)
-
-	//
+func (self *ServerManager) read_serverinfo(filename string) (*TServerInfoList, bool) {
 	var server_info_list TServerInfoList
 	if !util.LoadJsonFromFile[TServerInfoList](filename, &server_info_list) {
 		logout.LogError("[Load] Read server info fail")
-		return false
+		return nil, false
+	}
+	if server_info_list.RegistryInterval <= 0 {
+		server_info_list.RegistryInterval = REGISTRY_INTERVAL
+	}
+	if _, ok := NewBalance(server_info_list.Balance); !ok {
+		logout.LogError("[Load] Unknown balance: ", server_info_list.Balance)
+		return nil, false
 	}
 
 	vlist := server_info_list.List
 	for n, _ := range vlist {
-		vlist[n].ID = int(util.GenerateIDX(0))
 		if len(vlist[n].GateAddress) == 0 {
 			vlist[n].GateAddress = "127.0.0.1"
 		}
 		if vlist[n].GatePort == 0 {
 			vlist[n].GatePort = vlist[n].Port
 		}
+		if vlist[n].ProxyProtocol && len(vlist[n].ProxyTrusted) == 0 {
+			vlist[n].ProxyTrusted = []string{vlist[n].GateAddress}
+		}
+		if vlist[n].ConnectionsMaxNum <= 0 {
+			vlist[n].ConnectionsMaxNum = zutils.ZSERVER_CONNECTIONS_NUM
+		}
+		if vlist[n].DrainTimeout <= 0 {
+			vlist[n].DrainTimeout = DRAIN_TIMEOUT
+		}
+	}
+
+	// Stable ID: declared in config, or persisted in redis
+	var ids = make(map[int]string)
+	for n, _ := range vlist {
+		key := server_key(&vlist[n])
+		if vlist[n].ID <= 0 {
+			vlist[n].ID = resolve_server_id(key)
+		} else if !reserve_server_id(vlist[n].ID, key) {
+			return nil, false
+		}
+		if vlist[n].ID <= 0 {
+			logout.LogError("[Load] Resolve server id fail, ", key)
+			return nil, false
+		}
+		if other, ok := ids[vlist[n].ID]; ok {
+			logout.LogError("[Load] Repeated server id: ", vlist[n].ID, ", ", other, ", ", key)
+			return nil, false
+		}
+		ids[vlist[n].ID] = key
+	}
+	return &server_info_list, true
+}
+
+// Same key is same server between restarts: name, or bind address
+func server_key(info TPServerInfo) string {
+	if len(info.Name) > 0 {
+		return "name:" + info.Name
+	}
+	return fmt.Sprintf("%s/%s:%d", info.Type, info.Address, info.Port)
+}
+
+// Generated id reserved (unique for all nodes), then bound to key
+func resolve_server_id(key string) int {
+	id := database.DB_get_server_id(key)
+	if id > 0 {
+		return id
+	}
+
+	for n := 0; n < SERVER_ID_RETRY; n++ {
+		id = int(util.GenerateIDX(0))
+		if id <= 0 || !database.DB_reserve_server_id(id, key) {
+			continue
+		}
+		if !database.DB_create_server_id(key, id) {
+			// Same key resolved by another node
+			return database.DB_get_server_id(key)
+		}
+		return id
+	}
+	return 0
+}
+
+// Declared id reserved for key, false if used by another key
+func reserve_server_id(id int, key string) bool {
+	if database.DB_reserve_server_id(id, key) {
+		return true
+	}
+	other := database.DB_get_server_id_key(id)
+	if len(other) > 0 && other != key {
+		logout.LogError("[Load] Server id ", id, " is used by ", other, ", ", key)
+		return false
+	}
+	return true
+}
+
+//
+func (self *ServerManager) load_serverinfo(filename string) bool {
+	self.filename = filename
+
+	//
+	server_info_list, ok := self.read_serverinfo(filename)
+	if !ok {
+		return false
+	}
+	self.registry = server_info_list.Registry
+	self.registry_interval = server_info_list.RegistryInterval
+	balance, ok := NewBalance(server_info_list.Balance)
+	if !ok {
+		logout.LogWithName(LOG_GAMESERVER, "(Load) Unknown balance: ", server_info_list.Balance)
+		balance = &t_balance_default{}
+	}
+	self.set_balance(balance)
+	self.ticket_pkey = server_info_list.TicketPKey
+
+	vlist := server_info_list.List
+	self.info_lock.Lock()
+	self.servers_info = make(map[int]TPServerInfo)
+	for n, _ := range vlist {
 		self.servers_info[vlist[n].ID] = &vlist[n]
 	}
+	self.info_lock.Unlock()
 
 	//
 	return true
 }
 
+// Reload config: start new servers, drain removed servers, apply changed limits
+func (self *ServerManager) reload_serverinfo() bool {
+	server_info_list, ok := self.read_serverinfo(self.filename)
+	if !ok {
+		return false
+	}
+	if server_info_list.Registry != self.registry {
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Registry changed, need restart: ", server_info_list.Registry)
+	}
+	if server_info_list.TicketPKey != self.ticket_pkey {
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Ticket public key changed, need restart")
+	}
+
+	if balance, ok := NewBalance(server_info_list.Balance); !ok {
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Unknown balance, not changed: ", server_info_list.Balance)
+	} else if current := self.get_balance(); balance.Name() != current.Name() {
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Balance: ", current.Name(), " -> ", balance.Name())
+		self.set_balance(balance)
+	}
+
+	vlist := server_info_list.List
+	self.info_lock.RLock()
+	current := make(map[int]TPServerInfo)
+	for _, v := range self.servers_info {
+		current[v.ID] = v
+	}
+	self.info_lock.RUnlock()
+
+	var added []TPServerInfo
+	for n, _ := range vlist {
+		info := &vlist[n]
+
+		prev, ok := current[info.ID]
+		if !ok {
+			added = append(added, info)
+			continue
+		}
+		delete(current, info.ID)
+
+		// Changed in place
+		self.info_lock.Lock()
+		self.servers_info[info.ID] = info
+		self.info_lock.Unlock()
+
+		server := self.GetServer(info.ID)
+		if server == nil {
+			continue
+		}
+		// Added again while draining
+		if server.get_status() == STATUS_DRAINING {
+			if server.cancel_drain() {
+				logout.LogWithName(LOG_GAMESERVER, "(Reload) Cancel drain GameServer (ID:", info.ID, ")")
+			} else {
+				logout.LogWithName(LOG_GAMESERVER, "(Reload) GameServer (ID:", info.ID, ") is being freed, reload again")
+			}
+		}
+		if fields := server_info_restart_fields(prev, info); len(fields) > 0 {
+			logout.LogWithName(LOG_GAMESERVER, "(Reload) GameServer (ID:", info.ID, ") restart required, not applied: ",
+				strings.Join(fields, ", "))
+		}
+		if server.get_priority_level() != info.PriorityLevel || server.SessionsMaxNum() != int32(info.ConnectionsMaxNum) {
+			logout.LogWithName(LOG_GAMESERVER, "(Reload) Update GameServer (ID:", info.ID, "), Priority: ",
+				server.get_priority_level(), " -> ", info.PriorityLevel, ", Max Sessions: ",
+				server.SessionsMaxNum(), " -> ", info.ConnectionsMaxNum)
+		}
+		server.set_priority_level(info.PriorityLevel)
+		server.server.GetConnectionManager().SetMaxLen(info.ConnectionsMaxNum)
+		server.set_whitelist(info.Whitelist)
+		if server.set_maintenance(info.Maintenance, info.MaintenanceMessage) {
+			logout.LogWithName(LOG_GAMESERVER, "(Reload) Maintenance GameServer (ID:", info.ID, "): ",
+				info.Maintenance)
+		}
+	}
+
+	// Removed
+	for _, v := range current {
+		server := self.GetServer(v.ID)
+		if server == nil {
+			self.info_lock.Lock()
+			delete(self.servers_info, v.ID)
+			self.info_lock.Unlock()
+			continue
+		}
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Drain GameServer (ID:", v.ID, "), Sessions:",
+			server.SessionsNum())
+		self.drain_server(server, time.Duration(v.DrainTimeout)*time.Second)
+	}
+
+	// Added
+	result := true
+	for _, v := range added {
+		self.info_lock.Lock()
+		self.servers_info[v.ID] = v
+		self.info_lock.Unlock()
+
+		server := create_gameserver(v)
+		if server == nil {
+			self.info_lock.Lock()
+			delete(self.servers_info, v.ID)
+			self.info_lock.Unlock()
+
+			logout.LogWithName(LOG_GAMESERVER, "(Reload) Create GameServer failed, ", server_key(v))
+			result = false
+			continue
+		}
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Create GameServer (ID:", server.ID, "), Max Sessions:",
+			server.SessionsMaxNum(), " [OK]")
+	}
+	return result
+}
+
+// Stop assigning, free server when all sessions closed or timeout
+func (self *ServerManager) drain_server(server *t_server, timeout time.Duration) {
+	server.drain_lock.Lock()
+	if server.get_status() != STATUS_WORKING {
+		server.drain_lock.Unlock()
+		return
+	}
+	cancel := make(chan struct{})
+	server.drain_cancel = cancel
+	server.set_status(STATUS_DRAINING)
+	server.drain_lock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		deadline := time.Now().Add(timeout)
+		for server.SessionsNum() > 0 && time.Now().Before(deadline) {
+			select {
+			case <-cancel:
+				return
+			case <-ticker.C:
+			}
+		}
+
+		// Cancelled after the last check
+		server.drain_lock.Lock()
+		if server.drain_cancel != cancel {
+			server.drain_lock.Unlock()
+			return
+		}
+		server.drain_cancel = nil
+		server.drain_lock.Unlock()
+
+		logout.LogWithName(LOG_GAMESERVER, "(Reload) Free GameServer (ID:", server.ID, "), Sessions:",
+			server.SessionsNum())
+		self.del_server(server)
+
+		self.info_lock.Lock()
+		delete(self.servers_info, server.ID)
+		self.info_lock.Unlock()
+	}()
+}
+
+// Config fields applied only when the server starts (listeners, filter, packet, send)
+func server_info_restart_fields(prev TPServerInfo, info TPServerInfo) []string {
+	var fields []string
+	if prev.Type != info.Type || prev.Address != info.Address || prev.Port != info.Port ||
+		!reflect.DeepEqual(prev.Listeners, info.Listeners) {
+		fields = append(fields, "listeners")
+	}
+	if prev.IPFilter != info.IPFilter || prev.IPConnectionsMaxNum != info.IPConnectionsMaxNum {
+		fields = append(fields, "ip_filter")
+	}
+	if prev.ProxyProtocol != info.ProxyProtocol || !reflect.DeepEqual(prev.ProxyTrusted, info.ProxyTrusted) {
+		fields = append(fields, "proxy_protocol")
+	}
+	if prev.PacketSize != info.PacketSize || prev.FullMode != info.FullMode {
+		fields = append(fields, "packet_size, full_mode")
+	}
+	if prev.SendTimeout != info.SendTimeout || prev.SendPolicy != info.SendPolicy ||
+		prev.SendBacklogMaxNum != info.SendBacklogMaxNum {
+		fields = append(fields, "send")
+	}
+	return fields
+}
+
 //
 func (self *ServerManager) add_server(server *t_server) bool {
 	if server == nil || server.ID <= 0 {
@@ -143,7 +500,11 @@ func (self *ServerManager) del_server_by_id(id int) bool {
 	delete(self.servers_list, id)
 	self.servers_lock.Unlock()
 
-	v.status = STATUS_FREE
+	if self.registry {
+		database.DB_del_server_node(id)
+	}
+
+	v.set_status(STATUS_FREE)
 	return true
 }
 
@@ -155,7 +516,7 @@ func (self *ServerManager) del_server(server *t_server) bool {
 }
 
 func (self *ServerManager) free_server(server *t_server) bool {
-	server.status = STATUS_NULL
+	server.set_status(STATUS_NULL)
 
 	server.server.Stop()
 	server.release()
@@ -174,14 +535,31 @@ func (self *ServerManager) init_server(server *t_server) bool {
 	if !server.initialize() {
 		return false
 	}
-	server.server.Start()
+	if err := server.server.Start(); err != nil {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Start GameServer (ID:", server.ID, ") failed:", err.Error())
+		return false
+	}
 
-	server.status = STATUS_WORKING
+	server.set_status(STATUS_WORKING)
 	return true
 }
 
+func (self *ServerManager) get_balance() IBalance {
+	self.balance_lock.RLock()
+	defer self.balance_lock.RUnlock()
+	return self.balance
+}
+
+func (self *ServerManager) set_balance(balance IBalance) {
+	self.balance_lock.Lock()
+	self.balance = balance
+	self.balance_lock.Unlock()
+}
+
 func (self *ServerManager) GetServerInfo(id int) TPServerInfo {
+	self.info_lock.RLock()
 	si, ok := self.servers_info[id]
+	self.info_lock.RUnlock()
 	if !ok {
 		return nil
 	}
@@ -199,58 +577,127 @@ func (self *ServerManager) GetServer(id int) *t_server {
 }
 
 func (self *ServerManager) GetIdleServer() *t_server {
-	var s *t_server = nil
-	if !self.servers_lock.TryLock() {
+	node := self.get_idle_local(0, nil)
+	if node == nil {
 		return nil
 	}
+	return self.GetServer(node.ID)
+}
 
-	var s1, s2, s3 *t_server = nil, nil, nil
+// Exclude server by id (alternative for a full server)
+func (self *ServerManager) get_idle_local(exclude int, request *TBalanceRequest) *database.DBServerNode {
+	return select_idle_node(self.get_balance(), self.local_nodes(), exclude, request)
+}
+
+func (self *ServerManager) local_nodes() []*database.DBServerNode {
+	self.servers_lock.Lock()
+	var servers []*t_server
 	for _, v := range self.servers_list {
-		// priority level
-		if s1 == nil || (s1 != nil && s1.priority_level < v.priority_level) {
-			s1 = v
-		}
+		servers = append(servers, v)
+	}
+	self.servers_lock.Unlock()
 
-		// percentage < 80
-		percentage := v.SessionsUsedRatio() * 100
-		if s2 == nil || (percentage < 80 && v.SessionsUsedRatio() > s2.SessionsUsedRatio()) {
-			s2 = v
-		}
-		//
-		if s3 == nil || (percentage >= 80 && v.SessionsUsedRatio() < s3.SessionsUsedRatio()) {
-			s3 = v
+	// Working and draining, filtered by balance
+	var nodes []*database.DBServerNode
+	for _, v := range servers {
+		if !v.working() {
+			continue
 		}
+		nodes = append(nodes, v.node())
 	}
+	return nodes
+}
 
-	s = s1
-	if s2 != nil && s2.priority_level >= s.priority_level {
-		s = s2
+// Registry nodes of all processes, or local servers
+func (self *ServerManager) get_idle_node(exclude int, request *TBalanceRequest) *database.DBServerNode {
+	if self.registry {
+		return select_idle_node(self.get_balance(), database.DB_get_server_nodes(), exclude, request)
 	}
-	if s3 != nil && s3.priority_level >= s.priority_level {
-		s = s3
+	return self.get_idle_local(exclude, request)
+}
+
+// Heartbeat: register local servers, expired when process stopped
+func (self *ServerManager) start_registry() {
+	if !self.registry {
+		return
+	}
+
+	self.registry_stop = make(chan struct{})
+	interval := time.Duration(self.registry_interval) * time.Second
+	keep := float32(self.registry_interval * 3)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			self.servers_lock.Lock()
+			var servers []*t_server
+			for _, v := range self.servers_list {
+				servers = append(servers, v)
+			}
+			self.servers_lock.Unlock()
+
+			for _, v := range servers {
+				if !v.working() {
+					continue
+				}
+				if !database.DB_update_server_node(v.node(), keep) {
+					logout.LogWithName(LOG_GAMESERVER, "(Registry) Update GameServer node failed (ID:", v.ID, ")")
+				}
+			}
+
+			select {
+			case <-self.registry_stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (self *ServerManager) stop_registry() {
+	if self.registry_stop != nil {
+		close(self.registry_stop)
+		self.registry_stop = nil
+	}
+}
+
+// Users revoked by any process (login node logout, account status)
+func (self *ServerManager) start_revoke() bool {
+	self.revoke_stop = make(chan struct{})
+	return database.DB_subscribe_user_revoke(func(idx string, timestamp int64) {
+		if num := revoke_user(idx, timestamp); num > 0 {
+			logout.LogWithName(LOG_GAMESERVER, "(Revoke) User sessions kicked", logout.F("idx", idx), logout.F("sessions", num))
+		}
+	}, self.revoke_stop)
+}
+
+func (self *ServerManager) stop_revoke() {
+	if self.revoke_stop != nil {
+		close(self.revoke_stop)
+		self.revoke_stop = nil
 	}
-	self.servers_lock.Unlock()
-	return s
 }
 
 func create_gameserver(info TPServerInfo) *t_server {
 	var server = &t_server{
 		ID:        -1,
-		Token:     "",
 		timestamp: util.GetTimeStamp64(),
 
 		//
 		server: nil,
 
 		//
-		priority_level: info.PriorityLevel, //GAMESERVER_PRIORITY_NORMAL
+		priority_level: int32(info.PriorityLevel), //GAMESERVER_PRIORITY_NORMAL
 		status:         STATUS_NULL,
 	}
 
-	var code = util.GenerateAuthCode(4)
-
 	server.ID = info.ID
-	server.Token = util.MD5(strconv.FormatInt(int64(server.ID), 10) + "_" + code)
+	server.set_whitelist(info.Whitelist)
+	server.set_maintenance(info.Maintenance, info.MaintenanceMessage)
+	if !server.load_token(server_key(info)) {
+		return nil
+	}
 
 	if !GServerManager.add_server(server) {
 		return nil
@@ -261,18 +708,39 @@ func create_gameserver(info TPServerInfo) *t_server {
 		name = info.Name
 	}
 
+	var listeners []zutils.TListenerConfig
+	for _, v := range info.Listeners {
+		listeners = append(listeners, zutils.TListenerConfig{
+			Type:    v.Type,
+			Address: v.Address,
+			Port:    v.Port,
+		})
+	}
+
 	server.server = znet.NewServer(&zutils.TConfig{
-		Name:    name,
-		Type:    info.Type,
-		Address: info.Address,
-		Port:    info.Port,
+		Name:      name,
+		Type:      info.Type,
+		Address:   info.Address,
+		Port:      info.Port,
+		Listeners: listeners,
 
 		PacketSize:        uint32(info.PacketSize),
 		ConnectionsMaxNum: int32(info.ConnectionsMaxNum),
+		FullMode:          info.FullMode,
+
+		IPFilterFile:        info.IPFilter,
+		IPConnectionsMaxNum: int32(info.IPConnectionsMaxNum),
+
+		ProxyProtocol: info.ProxyProtocol,
+		ProxyTrusted:  info.ProxyTrusted,
+
+		SendTimeout:       int32(info.SendTimeout),
+		SendPolicy:        info.SendPolicy,
+		SendBacklogMaxLen: int32(info.SendBacklogMaxNum),
 	})
 
 	//
-	server.status = STATUS_INIT
+	server.set_status(STATUS_INIT)
 
 	if !GServerManager.init_server(server) {
 		GServerManager.del_server(server)
@@ -283,6 +751,8 @@ func create_gameserver(info TPServerInfo) *t_server {
 }
 
 func FreeGameServerAll() {
+	GServerManager.stop_revoke()
+	GServerManager.stop_registry()
 	GServerManager.del_server_all()
 }
 
@@ -290,12 +760,24 @@ func LoadGameServer(filename string) bool {
 
 	zutils.Global.LogDebug = false
 	logout.LogAdd(logout.LogLevel_Info, LOG_GAMESERVER, true, true)
+	logout.LogAdd(logout.LogLevel_Info, LOG_ZINX, true, true)
+	zlog.SetLogger(logout.NewLogger(LOG_ZINX))
 
 	if !GServerManager.initialize() || !GServerManager.load_serverinfo(filename) {
 		return false
 	}
+	// Login node not started yet (Redis): loaded on first ticket
+	if !LoadTicketVerifyKey(GServerManager.ticket_pkey) {
+		logout.LogWithName(LOG_GAMESERVER, "(Warning) Ticket public key not found, game auth refused until published")
+	}
+
+	GServerManager.info_lock.RLock()
+	var vlist []TPServerInfo
+	for _, v := range GServerManager.servers_info {
+		vlist = append(vlist, v)
+	}
+	GServerManager.info_lock.RUnlock()
 
-	vlist := GServerManager.servers_info
 	for _, v := range vlist {
 		server := create_gameserver(v)
 		if server == nil {
@@ -306,5 +788,143 @@ func LoadGameServer(filename string) bool {
 			(*server).SessionsMaxNum(), " [OK]")
 	}
 
+	GServerManager.start_registry()
+	if !GServerManager.start_revoke() {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Subscribe revoked users failed")
+		return false
+	}
+	return true
+}
+
+// Login node: idle server from registry (all processes), or local servers
+func GetIdleServerNode(registry bool, balance IBalance, request *TBalanceRequest) *database.DBServerNode {
+	if registry {
+		return select_idle_node(balance, database.DB_get_server_nodes(), 0, request)
+	}
+	return select_idle_node(balance, GServerManager.local_nodes(), 0, request)
+}
+
+// SIGHUP or admin call
+func ReloadGameServer() bool {
+	logout.LogWithName(LOG_GAMESERVER, "(Reload) Reload GameServer info: ", GServerManager.filename)
+	return GServerManager.reload_serverinfo()
+}
+
+// Admin call, connected players are notified
+func SetServerMaintenance(id int, enable bool, message string) bool {
+	server := GServerManager.GetServer(id)
+	if server == nil || !server.working() {
+		return false
+	}
+	if server.set_maintenance(enable, message) {
+		logout.LogWithName(LOG_GAMESERVER, "(Maintenance) GameServer (ID:", id, "): ", enable, ", ", message)
+	}
+	return true
+}
+
+// Admin call, replace maintenance whitelist (IDX)
+func SetServerWhitelist(id int, whitelist []string) bool {
+	server := GServerManager.GetServer(id)
+	if server == nil {
+		return false
+	}
+	server.set_whitelist(whitelist)
+	return true
+}
+
+// Admin call, local servers (registry nodes: database.DB_get_server_nodes)
+func GetServerNodes() []*database.DBServerNode {
+	nodes := GServerManager.local_nodes()
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// Metrics, local servers
+type TServerStats struct {
+	Node     *database.DBServerNode
+	QueueLen int
+	Messages []ziface.TMsgStat
+}
+
+func GetServerStats() []TServerStats {
+	var list []TServerStats
+	for _, node := range GetServerNodes() {
+		server := GServerManager.GetServer(node.ID)
+		if server == nil || server.server == nil {
+			continue
+		}
+		handler := server.server.GetMsgHandler()
+		list = append(list, TServerStats{
+			Node:     node,
+			QueueLen: handler.TaskQueueLen(),
+			Messages: handler.GetMsgStats().Snapshot(),
+		})
+	}
+	return list
+}
+
+// Admin call, close session by connection ID
+func KickSession(server_id int, sid uint32) bool {
+	server := GServerManager.GetServer(server_id)
+	if server == nil || !server.working() {
+		return false
+	}
+	return server.kick(sid)
+}
+
+// Admin call, close all sessions of the user, returns number closed
+func KickUser(idx string) int {
+	var num = 0
+	for _, user := range GUserManager.FindUsersByIDX(idx) {
+		if KickSession(user.ServerID, user.super.SID) {
+			num++
+		}
+	}
+	return num
+}
+
+// Logout or account disabled: tickets issued before refused, sessions kicked (this process)
+func revoke_user(idx string, timestamp int64) int {
+	ticket_revoked.revoke(idx, timestamp)
+	return KickUser(idx)
+}
+
+// Login node call: revoked in this process, then in all game processes (pub/sub)
+func PublishRevokeUser(idx string) int {
+	var timestamp = int64(util.GetTimeStamp64())
+	num := revoke_user(idx, timestamp)
+	if !database.DB_publish_user_revoke(idx, timestamp) {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Publish revoked user failed", logout.F("idx", idx))
+	}
+	return num
+}
+
+// Admin call, server_id 0 for all servers, returns number of sessions sent
+func Broadcast(server_id int, message string) int {
+	var num = 0
+	for _, v := range GetServerNodes() {
+		if server_id > 0 && v.ID != server_id {
+			continue
+		}
+		if server := GServerManager.GetServer(v.ID); server != nil && server.working() {
+			num += server.broadcast(message)
+		}
+	}
+	return num
+}
+
+// Admin call, previous token valid for TOKEN_PREV_TIME
+func RotateServerToken(id int) bool {
+	server := GServerManager.GetServer(id)
+	if server == nil {
+		return false
+	}
+	if !server.rotate_token() {
+		logout.LogWithName(LOG_GAMESERVER, "(Token) Rotate GameServer token failed (ID:", id, ")")
+		return false
+	}
+	logout.LogWithName(LOG_GAMESERVER, "(Token) Rotate GameServer token (ID:", id, ") [OK]")
 	return true
 }
@@ -0,0 +1,235 @@
+package gameserver
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/logout"
+	"mcmcx.com/mserver/src/util"
+)
+
+// Seconds, ticket of /auth valid to connect the game server
+const TICKET_TIME = 60.0 * 5
+
+// Seconds, used nonces purge interval
+const TICKET_PURGE_TIME = 60.0
+
+// Signing key, login nodes (ticket_key file, or Redis server_ticket_key)
+var ticket_sign_key atomic.Value // *ecdsa.PrivateKey
+
+// Verify key, game servers (config ticket_pkey or Redis server_ticket_pkey), tickets verified without Redis
+var ticket_verify_key atomic.Value // *ecdsa.PublicKey
+
+// Used nonces, kept until ticket expires
+// Process local: a ticket is bound to one server ID, served by one process (reserved ID),
+// nonces are lost on restart (a ticket of the last 5 minutes can be used again once)
+type t_ticket_nonces struct {
+	lock       sync.Mutex
+	list       map[string]int64
+	purge_time uint64
+}
+
+var ticket_nonces = &t_ticket_nonces{list: make(map[string]int64)}
+
+// false if used
+func (self *t_ticket_nonces) use(nonce string, expires int64) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var now = util.GetTimeStamp64()
+	if util.ExpiredTimestamp64(self.purge_time, TICKET_PURGE_TIME) <= 0 {
+		for k, v := range self.list {
+			if v < int64(now) {
+				delete(self.list, k)
+			}
+		}
+		self.purge_time = now
+	}
+
+	if _, ok := self.list[nonce]; ok {
+		return false
+	}
+	self.list[nonce] = expires
+	return true
+}
+
+// Revoked users (logout, account disabled), tickets issued before refused
+// Kept for TICKET_TIME, filled by revoke_user (login node call or pub/sub)
+type t_ticket_revoked struct {
+	lock       sync.Mutex
+	list       map[string]int64 // revoke timestamp64
+	purge_time uint64
+}
+
+var ticket_revoked = &t_ticket_revoked{list: make(map[string]int64)}
+
+// Revoke time of login node (same clock as ticket issue time)
+func (self *t_ticket_revoked) revoke(idx string, timestamp int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var now = util.GetTimeStamp64()
+	if util.ExpiredTimestamp64(self.purge_time, TICKET_PURGE_TIME) <= 0 {
+		for k, v := range self.list {
+			if util.ExpiredTimestamp64(uint64(v), TICKET_TIME) <= 0 {
+				delete(self.list, k)
+			}
+		}
+		self.purge_time = now
+	}
+	if timestamp > self.list[idx] {
+		self.list[idx] = timestamp
+	}
+}
+
+// Ticket of idx issued before revoke
+func (self *t_ticket_revoked) revoked(idx string, issued int64) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	revoke_time, ok := self.list[idx]
+	return ok && revoke_time >= issued
+}
+
+// Login node: key of file (login nodes only), or shared in Redis if no file, created if missing
+// Public key published for game servers (server_ticket_pkey)
+func LoadTicketSignKey(filename string) bool {
+	if ticket_sign_key.Load() != nil {
+		return true
+	}
+
+	var key *ecdsa.PrivateKey
+	if len(filename) > 0 {
+		key = load_ticket_key_file(filename)
+	} else {
+		logout.LogWithName(LOG_GAMESERVER, "(Warning) Ticket signing key in Redis, readable by game nodes, set ticket_key")
+		key = database.DB_load_server_key(database.DB_KEY_TICKET)
+	}
+	if key == nil {
+		return false
+	}
+	if !database.DB_update_ticket_public_key(util.ECCPublicKeyEncoding(&key.PublicKey)) {
+		return false
+	}
+	ticket_sign_key.Store(key)
+	return true
+}
+
+// Key file (x509 hex), created (0600) if not exists
+func load_ticket_key_file(filename string) *ecdsa.PrivateKey {
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		return util.ECCX509PrivateKeyDecoding(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Ticket key file: ", err.Error())
+		return nil
+	}
+
+	skey, _, err := util.ECCGenkey()
+	if err != nil {
+		return nil
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Ticket key file: ", err.Error())
+		return nil
+	}
+	defer file.Close()
+	if _, err := file.WriteString(util.ECCX509PrivateKeyEncoding(skey) + "\n"); err != nil {
+		logout.LogWithName(LOG_GAMESERVER, "(Error) Ticket key file: ", err.Error())
+		return nil
+	}
+	logout.LogWithName(LOG_GAMESERVER, "(Ticket) Key file created: ", filename)
+	return skey
+}
+
+// Game server: public key only, of config (hex) or of Redis if empty
+func LoadTicketVerifyKey(pkey string) bool {
+	if ticket_verify_key.Load() != nil {
+		return true
+	}
+
+	if len(pkey) == 0 {
+		pkey = database.DB_get_ticket_public_key()
+	}
+	key := util.ECCPublicKeyDecoding(pkey)
+	if key == nil {
+		return false
+	}
+	ticket_verify_key.Store(key)
+	return true
+}
+
+func ticket_private_key() *ecdsa.PrivateKey {
+	key, _ := ticket_sign_key.Load().(*ecdsa.PrivateKey)
+	return key
+}
+
+// Loaded on first ticket if no login node had published it at start
+func ticket_public_key() *ecdsa.PublicKey {
+	if !LoadTicketVerifyKey(GServerManager.ticket_pkey) {
+		return nil
+	}
+	key, _ := ticket_verify_key.Load().(*ecdsa.PublicKey)
+	return key
+}
+
+// Login server call, ticket of user for the game server
+func IssueTicket(idx string, server_id int, trace_id string) (string, bool) {
+	key := ticket_private_key()
+	if key == nil {
+		logout.LogWithName(LOG_GAMESERVER, "[ERROR] (Ticket) Signing key not loaded")
+		return "", false
+	}
+
+	var ticket = &util.TTicket{
+		IDX:      idx,
+		ServerID: server_id,
+		Expires:  int64(util.GetTimeStamp64()) + int64(TICKET_TIME*1000),
+		Nonce:    util.GenerateTicketNonce(),
+		TraceID:  trace_id,
+	}
+	text, err := util.TicketEncode(ticket, key)
+	if err != nil {
+		logout.LogWithName(LOG_GAMESERVER, "[ERROR] (Ticket) Sign failed:", err.Error())
+		return "", false
+	}
+	return text, true
+}
+
+// Signature, user, server and expires, nonce used once, claims of ticket if ok
+// 1: ok
+// 0: failed (sign, user or server)
+// -1: expired
+// -2: reused
+// -3: error (format, key)
+// -4: revoked (logout before)
+func verify_ticket(text string, idx string, server_id int) (*util.TTicket, int) {
+	key := ticket_public_key()
+	if key == nil {
+		return nil, -3
+	}
+
+	ticket, result := util.TicketDecode(text, key)
+	if result == -2 {
+		return nil, -3
+	}
+	if ticket == nil || ticket.IDX != idx || ticket.ServerID != server_id {
+		return nil, 0
+	}
+	if ticket.Expires < int64(util.GetTimeStamp64()) {
+		return nil, -1
+	}
+	if ticket_revoked.revoked(ticket.IDX, ticket.Expires-int64(TICKET_TIME*1000)) {
+		return nil, -4
+	}
+	if !ticket_nonces.use(ticket.Nonce, ticket.Expires) {
+		return nil, -2
+	}
+	return ticket, 1
+}
@@ -0,0 +1,87 @@
+package gameserver
+
+import (
+	"testing"
+
+	"mcmcx.com/mserver/src/util"
+)
+
+func test_ticket_keys(t *testing.T) {
+	if ticket_private_key() != nil {
+		return
+	}
+	skey, _, err := util.ECCGenkey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket_sign_key.Store(skey)
+	if !LoadTicketVerifyKey(util.ECCPublicKeyEncoding(&skey.PublicKey)) {
+		t.Fatal("verify key")
+	}
+}
+
+func test_issue(t *testing.T, idx string, server_id int) string {
+	text, ok := IssueTicket(idx, server_id, "trace-1")
+	if !ok {
+		t.Fatal("issue ticket")
+	}
+	return text
+}
+
+func TestVerifyTicket(t *testing.T) {
+	test_ticket_keys(t)
+	text := test_issue(t, "10001", 3)
+
+	if _, result := verify_ticket(text, "10002", 3); result != 0 {
+		t.Fatalf("other user: %d", result)
+	}
+	if _, result := verify_ticket(text, "10001", 4); result != 0 {
+		t.Fatalf("other server: %d", result)
+	}
+	if _, result := verify_ticket("bad.ticket", "10001", 3); result != -3 {
+		t.Fatalf("format: %d", result)
+	}
+
+	ticket, result := verify_ticket(text, "10001", 3)
+	if result != 1 || ticket.TraceID != "trace-1" {
+		t.Fatalf("verify: %d", result)
+	}
+	if _, result := verify_ticket(text, "10001", 3); result != -2 {
+		t.Fatalf("second use: %d", result)
+	}
+}
+
+func TestVerifyTicketExpired(t *testing.T) {
+	test_ticket_keys(t)
+	var ticket = &util.TTicket{
+		IDX:      "10003",
+		ServerID: 3,
+		Expires:  int64(util.GetTimeStamp64()) - 1000,
+		Nonce:    util.GenerateTicketNonce(),
+	}
+	text, err := util.TicketEncode(ticket, ticket_private_key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, result := verify_ticket(text, "10003", 3); result != -1 {
+		t.Fatalf("expired: %d", result)
+	}
+}
+
+func TestVerifyTicketRevoked(t *testing.T) {
+	test_ticket_keys(t)
+
+	// Issued before revoke
+	text := test_issue(t, "10004", 3)
+	ticket_revoked.revoke("10004", int64(util.GetTimeStamp64()))
+	if _, result := verify_ticket(text, "10004", 3); result != -4 {
+		t.Fatalf("revoked: %d", result)
+	}
+
+	// Issued after revoke
+	ticket_revoked.revoke("10005", int64(util.GetTimeStamp64())-1000)
+	text = test_issue(t, "10005", 3)
+	if _, result := verify_ticket(text, "10005", 3); result != 1 {
+		t.Fatalf("issued after revoke: %d", result)
+	}
+}
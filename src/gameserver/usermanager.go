@@ -1,6 +1,8 @@
 package gameserver
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
 	"mcmcx.com/mserver/src/logout"
@@ -80,6 +82,75 @@ func (self *UserManager) IDN() int {
 	return self.idn
 }
 
+// Admin list
+type TUserStatus struct {
+	ID         int    `json:"id"`
+	Type       string `json:"type"`
+	SID        uint32 `json:"sid"`
+	Address    string `json:"address"`
+	IDX        string `json:"idx"`
+	ServerID   int    `json:"server_id"`
+	ServerName string `json:"server_name"`
+	TraceID    string `json:"trace_id,omitempty"`
+}
+
+func user_status(user i_user) TUserStatus {
+	var status = TUserStatus{
+		ID:      user.ID(),
+		Type:    user.Type(),
+		SID:     user.parent().SID,
+		Address: user.RemoteAddress(),
+	}
+	if v, ok := user.(*TUser); ok {
+		status.IDX = v.IDX
+		status.ServerID = v.ServerID
+		status.ServerName = v.ServerName
+		status.TraceID = v.TraceID
+	}
+	return status
+}
+
+// Search in idx or address (empty for all), limit <= 0 for all
+func (self *UserManager) Users(search string, limit int) []TUserStatus {
+	self.lock.Lock()
+	var list []TUserStatus
+	for _, v := range self.list {
+		status := user_status(v)
+		if len(search) > 0 && !strings.Contains(status.IDX, search) &&
+			!strings.Contains(status.Address, search) {
+			continue
+		}
+		list = append(list, status)
+	}
+	self.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// Same idx may be online on more than one session
+func (self *UserManager) FindUsersByIDX(idx string) []*TUser {
+	if len(idx) == 0 {
+		return nil
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var list []*TUser
+	for _, v := range self.list {
+		if user, ok := v.(*TUser); ok && user.IDX == idx {
+			list = append(list, user)
+		}
+	}
+	return list
+}
+
 //
 func (self *UserManager) GetUser(id int) i_user {
 	user := self.get_user_by_id(id)
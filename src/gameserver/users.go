@@ -68,8 +68,9 @@ type TUser struct {
 	i_user
 	super t_user_base
 
-	IDX   string
-	Token string
+	IDX     string
+	Token   string
+	TraceID string // HTTP auth correlation
 
 	//
 	ClientTimestamp32 uint32
@@ -0,0 +1,33 @@
+package logout
+
+import "fmt"
+
+// Named log as a leveled logger (zinx ziface.ILogger)
+//	zlog.SetLogger(logout.NewLogger("ZINX"))
+type Logger struct {
+	name string
+}
+
+func NewLogger(name string) *Logger {
+	return &Logger{name: name}
+}
+
+func (self *Logger) Name() string {
+	return self.name
+}
+
+func (self *Logger) DebugF(format string, v ...interface{}) {
+	log_output(self.name, LogLevel_Debug, []interface{}{fmt.Sprintf(format, v...)})
+}
+
+func (self *Logger) InfoF(format string, v ...interface{}) {
+	log_output(self.name, LogLevel_Info, []interface{}{fmt.Sprintf(format, v...)})
+}
+
+func (self *Logger) WarnF(format string, v ...interface{}) {
+	log_output(self.name, LogLevel_Warnning, []interface{}{fmt.Sprintf(format, v...)})
+}
+
+func (self *Logger) ErrorF(format string, v ...interface{}) {
+	log_output(self.name, LogLevel_Error, []interface{}{fmt.Sprintf(format, v...)})
+}
@@ -1,7 +1,8 @@
 package logout
 
 import (
-	"container/list"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
@@ -19,16 +20,57 @@ const (
 	LogLevel_Max      int = 4
 )
 
+// Output format per named log
+const (
+	LogFormat_Text = "text"
+	LogFormat_JSON = "json"
+)
+
+// Key/value field, LogWithName(name, "message", logout.F("idx", idx))
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) LogField {
+	return LogField{Key: key, Value: value}
+}
+
+type log_entry struct {
+	time    time.Time
+	level   int
+	message string
+	fields  []LogField
+}
+
+// Named logs config (data/LogInfo.json)
+//	{"level": "info", "logs": {"gameserver": {"format": "json", "level": "debug", "max_size": 100, "max_days": 30}}}
+type LogConfig struct {
+	Format string `json:"format"`
+	// Minimum level (debug, info, warn, error), default level of all logs if empty
+	Level string `json:"level"`
+	// File rotation: size (MB), days (< 0: keep all), files count (0: no limit), gzip (default true)
+	MaxSize  int   `json:"max_size"`
+	MaxDays  int   `json:"max_days"`
+	MaxFiles int   `json:"max_files"`
+	Compress *bool `json:"compress"`
+}
+
+type LogConfigList struct {
+	Level string               `json:"level"`
+	Logs  map[string]LogConfig `json:"logs"`
+}
+
 type LogItem struct {
-	level        int
+	level        int // default of calls
+	min_level    int // lower levels dropped
+	config_level bool
 	name         string
-	filename     string
+	format       string
 	output_file  bool
 	output_print bool
 	output_time  bool
-	lock         *sync.Mutex
-	doing        bool //write log
-	values       *list.List
+	sink         *log_sink
 }
 
 type LogStats struct {
@@ -36,17 +78,26 @@ type LogStats struct {
 	eof    string
 	cwd    string
 	dir    string
+	tag    string // file name tag of process (command), processes of one cwd share logs dir
 	items  map[string]LogItem
+	level  int // minimum level of logs without config level
+	//
+	configs map[string]LogConfig
+	// Kept when log added again
+	sinks map[string]*log_sink
+	lock  sync.RWMutex
 }
 
 var log_stats LogStats
 var log_init_completed = false
 
-func LogInit() bool {
+// tag: added to log file names (<name>_<tag>_<date>.log), empty for <name>_<date>.log
+func LogInit(tag string) bool {
 	if log_init_completed {
 		return true
 	}
 
+	log_stats.tag = strings.ToLower(tag)
 	log_stats.cwd = "."
 	log_stats.eof = "\n"
 	log_stats.dir = "logs"
@@ -75,6 +126,9 @@ func LogInit() bool {
 	}
 
 	log_stats.items = make(map[string]LogItem)
+	log_stats.configs = make(map[string]LogConfig)
+	log_stats.sinks = make(map[string]*log_sink)
+	log_stats.level = LogLevel_Info
 	log_stats.status = 0
 	log_init_completed = true
 
@@ -82,6 +136,7 @@ func LogInit() bool {
 	LogAdd(LogLevel_Error, "Error", true, true)
 	LogAdd(LogLevel_Warnning, "Warnning", true, true)
 	LogAdd(LogLevel_Info, "Info", false, true)
+	LogAdd(LogLevel_Debug, "Debug", false, true)
 	return true
 }
 
@@ -96,108 +151,351 @@ func LogAdd(level int, name string, output_file bool, output_print bool) bool {
 	item.output_file = output_file
 	item.output_print = output_print
 
-	item.doing = false
-	item.lock = &sync.Mutex{}
-	item.values = nil
-
 	item.level = level
 	if item.level >= LogLevel_Max {
 		item.level = LogLevel_Info
 	}
 	item.name = strings.ToLower(name)
 
-	var date = time.Now()
-	var filename = fmt.Sprintf("%s_%4d%02d%02d", item.name, date.Year(), date.Month(), date.Day())
-	item.filename = filename + ".log"
+	log_stats.lock.Lock()
+	defer log_stats.lock.Unlock()
+
+	item.format = LogFormat_Text
+	item.min_level = log_stats.level
+	config, ok := log_stats.configs[item.name]
+	if ok {
+		log_apply_config(&item, config)
+	}
+
+	if output_file {
+		item.sink, ok = log_stats.sinks[item.name]
+		if !ok {
+			item.sink = new_log_sink(log_file_name(item.name), log_stats.dir, config)
+			log_stats.sinks[item.name] = item.sink
+		}
+	}
 
 	log_stats.items[item.name] = item
 	return true
 }
 
-func log_output(name string, text string) {
+// File name prefix of log, files of other processes (tags) not written or cleaned
+func log_file_name(name string) string {
+	if len(log_stats.tag) > 0 {
+		return name + "_" + log_stats.tag
+	}
+	return name
+}
+
+// Write queued lines of all logs to files, called before exit
+func Flush() bool {
+	if !log_init_completed {
+		return false
+	}
+
+	log_stats.lock.RLock()
+	var sinks []*log_sink
+	for _, v := range log_stats.sinks {
+		sinks = append(sinks, v)
+	}
+	log_stats.lock.RUnlock()
+
+	var result = true
+	for _, v := range sinks {
+		if !v.flush(LOG_FLUSH_TIMEOUT) {
+			result = false
+		}
+	}
+	return result
+}
+
+// Load named logs config, applied to logs added before and after
+func LogLoadConfig(filename string) bool {
+	if !log_init_completed {
+		return false
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		println("[Error] Read log config (" + filename + ") fail: " + err.Error())
+		return false
+	}
+	var config_list LogConfigList
+	if err = json.Unmarshal(data, &config_list); err != nil {
+		println("[Error] Parse log config (" + filename + ") fail: " + err.Error())
+		return false
+	}
+
+	log_stats.lock.Lock()
+	defer log_stats.lock.Unlock()
+
+	// Read under lock, set by LogSetLevel
+	var level = log_stats.level
+	if len(config_list.Level) > 0 {
+		var ok bool
+		if level, ok = LogParseLevel(config_list.Level); !ok {
+			println("[Error] Unknown log level (" + config_list.Level + ")")
+			return false
+		}
+	}
+
+	log_set_default_level(level)
+	for name, config := range config_list.Logs {
+		var key = strings.TrimSpace(strings.ToLower(name))
+		log_stats.configs[key] = config
+		if item, ok := log_stats.items[key]; ok {
+			log_apply_config(&item, config)
+			log_stats.items[key] = item
+		}
+		if sink, ok := log_stats.sinks[key]; ok {
+			sink.reconfigure(config)
+		}
+	}
+	return true
+}
+
+func log_apply_config(item *LogItem, config LogConfig) {
+	switch strings.ToLower(config.Format) {
+	case LogFormat_JSON:
+		item.format = LogFormat_JSON
+	case LogFormat_Text, "":
+		item.format = LogFormat_Text
+	default:
+		println("[Error] Unknown log format (" + config.Format + "), log: " + item.name)
+	}
+
+	if len(config.Level) > 0 {
+		if level, ok := LogParseLevel(config.Level); ok {
+			item.min_level = level
+			item.config_level = true
+		} else {
+			println("[Error] Unknown log level (" + config.Level + "), log: " + item.name)
+		}
+	}
+}
+
+// Logs with own level (config or set by name) keep it
+func log_set_default_level(level int) {
+	log_stats.level = level
+	for key, item := range log_stats.items {
+		if !item.config_level {
+			item.min_level = level
+			log_stats.items[key] = item
+		}
+	}
+}
+
+// debug, info, warn (warning, warnning), error
+func LogParseLevel(name string) (int, bool) {
+	switch strings.TrimSpace(strings.ToLower(name)) {
+	case "debug":
+		return LogLevel_Debug, true
+	case "info":
+		return LogLevel_Info, true
+	case "warn", "warning", "warnning":
+		return LogLevel_Warnning, true
+	case "error":
+		return LogLevel_Error, true
+	}
+	return LogLevel_Info, false
+}
+
+// Severity order, level constants are not ordered (Info is 0)
+func log_level_rank(level int) int {
+	switch level {
+	case LogLevel_Debug:
+		return 0
+	case LogLevel_Warnning:
+		return 2
+	case LogLevel_Error:
+		return 3
+	}
+	return 1
+}
+
+func log_enabled(item LogItem, level int) bool {
+	return log_level_rank(level) >= log_level_rank(item.min_level)
+}
+
+// Minimum level at runtime, empty name for default of all logs (own levels cleared)
+func LogSetLevel(name string, level int) bool {
+	if !log_init_completed || level < 0 || level >= LogLevel_Max {
+		return false
+	}
+
+	log_stats.lock.Lock()
+	defer log_stats.lock.Unlock()
+
+	var key = strings.TrimSpace(strings.ToLower(name))
+	if len(key) == 0 {
+		for key, item := range log_stats.items {
+			item.config_level = false
+			log_stats.items[key] = item
+		}
+		log_set_default_level(level)
+		return true
+	}
+
+	item, ok := log_stats.items[key]
+	if !ok {
+		return false
+	}
+	item.min_level = level
+	item.config_level = true
+	log_stats.items[key] = item
+	return true
+}
+
+// Minimum level name of logs, "" for default
+func LogLevels() map[string]string {
+	var result = make(map[string]string)
+	if !log_init_completed {
+		return result
+	}
+
+	log_stats.lock.RLock()
+	defer log_stats.lock.RUnlock()
+
+	result[""] = log_level_name(log_stats.level)
+	for key, item := range log_stats.items {
+		result[key] = log_level_name(item.min_level)
+	}
+	return result
+}
+
+// level < 0: level of log
+func log_output(name string, level int, args []interface{}) {
 	if !log_init_completed {
 		return
 	}
 
 	var key = strings.TrimSpace(strings.ToLower(name))
+	log_stats.lock.RLock()
 	var item, ok = log_stats.items[key]
-	if ok {
-		item.lock.Lock()
-		if item.values == nil {
-			item.values = list.New()
+	log_stats.lock.RUnlock()
+	if !ok {
+		return
+	}
+
+	if level < 0 || level >= LogLevel_Max {
+		level = item.level
+	}
+	if !log_enabled(item, level) {
+		return
+	}
+	var entry = log_entry_args(args)
+	entry.level = level
+
+	if item.format == LogFormat_JSON {
+		text := log_format_json(item, entry)
+		if item.output_print {
+			println(text)
 		}
-		item.values.PushBack(text)
-		item.lock.Unlock()
-
-		if item.lock.TryLock() {
-			// Set list to temp, if doing is true.
-			var lvalues *list.List = nil
-			if !item.doing {
-				item.doing = true
-				lvalues = item.values
-				item.values = nil
-			}
-			item.lock.Unlock()
-
-			if lvalues != nil {
-				for v := lvalues.Front(); v != nil; v = v.Next() {
-					if item.output_print {
-						log_print(item, v.Value.(string))
-					}
-					if item.output_file {
-						log_file(item, v.Value.(string))
-					}
-				}
-			}
-			item.doing = false
+		if item.output_file && item.sink != nil {
+			item.sink.write(text)
 		}
+		return
+	}
+
+	text := log_format_text(entry)
+	if item.output_print {
+		log_print(entry.level, text)
+	}
+	if item.output_file && item.sink != nil {
+		item.sink.write(log_text_line(item, entry.level, entry.time, text))
 	}
 }
 
-func log_print(item LogItem, text string) {
-	if item.level == LogLevel_Error {
+func log_print(level int, text string) {
+	if level == LogLevel_Error {
 		println("[ERROR] " + text)
-	} else if item.level == LogLevel_Warnning {
+	} else if level == LogLevel_Warnning {
 		println("[WARNNING] " + text)
-	} else if item.level == LogLevel_Debug {
+	} else if level == LogLevel_Debug {
 		println("[DEBUG] " + text)
 	} else {
 		println("[INFO] " + text)
 	}
 }
 
-func log_file(item LogItem, text string) bool {
-	var fullname = fmt.Sprintf("logs/%s", item.filename)
+func log_level_name(level int) string {
+	switch level {
+	case LogLevel_Error:
+		return "error"
+	case LogLevel_Warnning:
+		return "warn"
+	case LogLevel_Debug:
+		return "debug"
+	}
+	return "info"
+}
+
+// message key=value key="value with spaces"
+func log_format_text(entry log_entry) string {
+	if len(entry.fields) == 0 {
+		return entry.message
+	}
+
+	var buffer strings.Builder
+	buffer.WriteString(entry.message)
+	for _, field := range entry.fields {
+		value := log_parse_args(field.Value)
+		if len(value) == 0 || strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		buffer.WriteString(" " + field.Key + "=" + value)
+	}
+	return buffer.String()
+}
+
+// One object per line: time, level, log, msg, fields
+func log_format_json(item LogItem, entry log_entry) string {
+	var buffer bytes.Buffer
+	log_json_field(&buffer, "time", entry.time.Format(time.RFC3339Nano), true)
+	log_json_field(&buffer, "level", log_level_name(entry.level), false)
+	log_json_field(&buffer, "log", item.name, false)
+	log_json_field(&buffer, "msg", entry.message, false)
+	for _, field := range entry.fields {
+		log_json_field(&buffer, field.Key, field.Value, false)
+	}
+	buffer.WriteString("}")
+	return buffer.String()
+}
 
-	file, err := os.OpenFile(fullname, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+func log_json_field(buffer *bytes.Buffer, key string, value interface{}, first bool) {
+	if first {
+		buffer.WriteString("{")
+	} else {
+		buffer.WriteString(",")
+	}
+
+	name, _ := json.Marshal(key)
+	data, err := json.Marshal(value)
 	if err != nil {
-		println("[Error] Append log (%s) fail.", item.filename)
-		return false
+		data, _ = json.Marshal(fmt.Sprintf("%+v", value))
 	}
+	buffer.Write(name)
+	buffer.WriteString(":")
+	buffer.Write(data)
+}
 
+func log_text_line(item LogItem, level int, tm time.Time, text string) string {
 	var value = ""
-	if item.level == LogLevel_Error {
+	if level == LogLevel_Error {
 		value = "[ERROR] " + text
-	} else if item.level == LogLevel_Warnning {
+	} else if level == LogLevel_Warnning {
 		value = "[WARNNING] " + text
-	} else if item.level == LogLevel_Debug {
+	} else if level == LogLevel_Debug {
 		value = "[DEBUG] " + text
 	} else {
 		value = "[INFO] " + text
 	}
 
 	if item.output_time {
-		var tm = time.Now()
 		var ss = fmt.Sprintf("%02d:%02d:%02d", tm.Hour(), tm.Minute(), tm.Second())
 		value = ss + " " + value
 	}
-
-	// Set file end, append line.
-	file.Seek(0, os.SEEK_END)
-	file.WriteString(value + log_stats.eof)
-	file.Close()
-
-	return true
+	return value
 }
 
 func log_args(args ...interface{}) string {
@@ -272,27 +570,45 @@ func log_parse_args(args interface{}) string {
 	return text
 }
 
+// Fields (LogField) are taken out of args, others are joined as message
+func log_entry_args(args []interface{}) log_entry {
+	var entry = log_entry{time: time.Now()}
+	var values []interface{}
+	for _, v := range args {
+		switch v.(type) {
+		case LogField:
+			entry.fields = append(entry.fields, v.(LogField))
+		case []LogField:
+			entry.fields = append(entry.fields, v.([]LogField)...)
+		default:
+			values = append(values, v)
+		}
+	}
+	entry.message = log_args(values)
+	return entry
+}
+
 func LogWithName(name string, args ...interface{}) {
-	var values = log_args(args)
-	log_output(name, values)
+	log_output(name, -1, args)
+}
+
+// Level of this call, LogWithLevel(LOG_USER, logout.LogLevel_Debug, "...")
+func LogWithLevel(name string, level int, args ...interface{}) {
+	log_output(name, level, args)
 }
 
 func Log(args ...interface{}) {
-	var values = log_args(args)
-	log_output("Info", values)
+	log_output("Info", -1, args)
 }
 
 func LogDebug(args ...interface{}) {
-	var values = log_args(args)
-	log_output("Debug", values)
+	log_output("Debug", -1, args)
 }
 
 func LogWarn(args ...interface{}) {
-	var values = log_args(args)
-	log_output("Warnning", values)
+	log_output("Warnning", -1, args)
 }
 
 func LogError(args ...interface{}) {
-	var values = log_args(args)
-	log_output("Error", values)
+	log_output("Error", -1, args)
 }
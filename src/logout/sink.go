@@ -0,0 +1,306 @@
+package logout
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of file sink
+const (
+	LOG_SINK_QUEUE    = 4096
+	LOG_SINK_MAXSIZE  = 100 // MB
+	LOG_SINK_MAXDAYS  = 30
+	LOG_SINK_INTERVAL = time.Second // buffer flush
+	LOG_FLUSH_TIMEOUT = 3 * time.Second
+)
+
+// Log file: buffered background writer, rotation by day and size,
+// old files compressed (gzip) and removed by days or count
+//	logs/<name>_<YYYYMMDD>.log, logs/<name>_<YYYYMMDD>.<n>.log(.gz)
+// name: log name and process tag (log_file_name), a file is written by one process
+type log_sink struct {
+	name string
+	dir  string
+
+	//
+	max_size  int64
+	max_files int
+	max_days  int
+	compress  bool
+
+	//
+	queue   chan string
+	flushs  chan chan struct{}
+	configs chan LogConfig
+	dropped uint64
+
+	// Writer goroutine only
+	file   *os.File
+	writer *bufio.Writer
+	date   string
+	index  int
+	size   int64
+
+	//
+	cleanup_lock sync.Mutex
+	current      atomic.Value // opened file path
+	pattern      *regexp.Regexp
+}
+
+func new_log_sink(name string, dir string, config LogConfig) *log_sink {
+	var sink = &log_sink{
+		name:    name,
+		dir:     dir,
+		queue:   make(chan string, LOG_SINK_QUEUE),
+		flushs:  make(chan chan struct{}),
+		configs: make(chan LogConfig, 1),
+		pattern: regexp.MustCompile("^" + regexp.QuoteMeta(name) + `_\d{8}(\.\d+)?\.log(\.gz)?$`),
+	}
+	sink.configure(config)
+
+	go sink.run()
+	return sink
+}
+
+// Applied by writer goroutine
+func (self *log_sink) reconfigure(config LogConfig) {
+	self.configs <- config
+}
+
+func (self *log_sink) configure(config LogConfig) {
+	self.max_size = int64(LOG_SINK_MAXSIZE) * 1024 * 1024
+	if config.MaxSize > 0 {
+		self.max_size = int64(config.MaxSize) * 1024 * 1024
+	}
+	self.max_days = LOG_SINK_MAXDAYS
+	if config.MaxDays != 0 {
+		self.max_days = config.MaxDays // < 0: keep all
+	}
+	self.max_files = config.MaxFiles
+	self.compress = config.Compress == nil || *config.Compress
+}
+
+// Never blocks the caller, lines dropped if queue is full
+func (self *log_sink) write(line string) {
+	select {
+	case self.queue <- line:
+	default:
+		atomic.AddUint64(&self.dropped, 1)
+	}
+}
+
+// Wait queued lines written to file
+func (self *log_sink) flush(timeout time.Duration) bool {
+	var done = make(chan struct{})
+	select {
+	case self.flushs <- done:
+	case <-time.After(timeout):
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (self *log_sink) run() {
+	ticker := time.NewTicker(LOG_SINK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line := <-self.queue:
+			self.write_line(line)
+		case <-ticker.C:
+			self.write_dropped()
+			if self.writer != nil {
+				self.writer.Flush()
+			}
+		case config := <-self.configs:
+			self.configure(config)
+		case done := <-self.flushs:
+			for n := len(self.queue); n > 0; n-- {
+				self.write_line(<-self.queue)
+			}
+			self.write_dropped()
+			if self.writer != nil {
+				self.writer.Flush()
+				self.file.Sync()
+			}
+			close(done)
+		}
+	}
+}
+
+func (self *log_sink) write_dropped() {
+	dropped := atomic.SwapUint64(&self.dropped, 0)
+	if dropped > 0 {
+		self.write_line(fmt.Sprintf("[WARNNING] (Log) Queue full, dropped lines: %d", dropped))
+	}
+}
+
+func (self *log_sink) write_line(line string) {
+	var date = time.Now().Format("20060102")
+	if self.file == nil || date != self.date {
+		self.open(date)
+	} else if self.max_size > 0 && self.size+int64(len(line)) > self.max_size {
+		self.index++
+		self.open(date)
+	}
+	if self.writer == nil {
+		return
+	}
+
+	n, _ := self.writer.WriteString(line + log_stats.eof)
+	self.size += int64(n)
+}
+
+func (self *log_sink) filename(date string, index int) string {
+	if index > 0 {
+		return filepath.Join(self.dir, fmt.Sprintf("%s_%s.%d.log", self.name, date, index))
+	}
+	return filepath.Join(self.dir, fmt.Sprintf("%s_%s.log", self.name, date))
+}
+
+// New day starts from index 0, full files (restart) skipped
+func (self *log_sink) open(date string) {
+	self.close()
+
+	if date != self.date {
+		self.date = date
+		self.index = 0
+	}
+
+	var fullname = self.filename(self.date, self.index)
+	for {
+		info, err := os.Stat(fullname)
+		if err != nil || self.max_size <= 0 || info.Size() < self.max_size {
+			break
+		}
+		self.index++
+		fullname = self.filename(self.date, self.index)
+	}
+
+	file, err := os.OpenFile(fullname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		println("[Error] Open log (" + fullname + ") fail: " + err.Error())
+		return
+	}
+	var size int64 = 0
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	self.file = file
+	self.writer = bufio.NewWriterSize(file, 64*1024)
+	self.size = size
+	self.current.Store(fullname)
+
+	go self.cleanup(self.compress, self.max_days, self.max_files)
+}
+
+func (self *log_sink) close() {
+	if self.file == nil {
+		return
+	}
+	self.writer.Flush()
+	self.file.Close()
+	self.file = nil
+	self.writer = nil
+}
+
+// Compress closed files, remove by days and count (current file kept)
+func (self *log_sink) cleanup(compress bool, max_days int, max_files int) {
+	self.cleanup_lock.Lock()
+	defer self.cleanup_lock.Unlock()
+
+	entries, err := os.ReadDir(self.dir)
+	if err != nil {
+		return
+	}
+	// Loaded after listing, files rotated later are not listed
+	current, _ := self.current.Load().(string)
+
+	type t_file struct {
+		path    string
+		modtime time.Time
+	}
+	var files []t_file
+	for _, entry := range entries {
+		if entry.IsDir() || !self.pattern.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(self.dir, entry.Name())
+		if path == current {
+			continue
+		}
+		if compress && filepath.Ext(path) == ".log" {
+			if compressed, ok := log_gzip(path); ok {
+				path = compressed
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, t_file{path: path, modtime: info.ModTime()})
+	}
+
+	// Newest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modtime.After(files[j].modtime)
+	})
+	var expired = time.Now().AddDate(0, 0, -max_days)
+	for n, v := range files {
+		if (max_days > 0 && v.modtime.Before(expired)) ||
+			(max_files > 0 && n+1 >= max_files) {
+			os.Remove(v.path)
+		}
+	}
+}
+
+// path.gz, source removed
+func log_gzip(path string) (string, bool) {
+	source, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer source.Close()
+
+	var target_path = path + ".gz"
+	target, err := os.OpenFile(target_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return "", false
+	}
+
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+	if close_err := target.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(target_path)
+		return "", false
+	}
+
+	// Keep modify time for retention
+	if info, err := source.Stat(); err == nil {
+		os.Chtimes(target_path, info.ModTime(), info.ModTime())
+	}
+	os.Remove(path)
+	return target_path, true
+}
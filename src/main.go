@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/gin-gonic/gin"
 	"mcmcx.com/mserver/src/database"
 	"mcmcx.com/mserver/src/gameserver"
 	"mcmcx.com/mserver/src/logout"
@@ -14,55 +16,107 @@ import (
 
 func main() {
 
-	logout.LogInit()
+	command, err := parse_command(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err.Error())
+		os.Exit(2)
+	}
+
+	logout.LogInit(command.log_tag())
+	if len(command.log_config) > 0 && !logout.LogLoadConfig(command.log_config) {
+		fmt.Fprintln(os.Stderr, "Error:", "load log config error.")
+		os.Exit(2)
+	}
 	logout.Log("Logout init ...")
 
-	logout.Log("Database (Redis) init ...")
-	if !database.RedisInitialize("data/ServerInfo.json") {
-		logout.LogError("[Database] Error: ", "initialize redis error.")
-		return
+	if err = start(command); err != nil {
+		logout.LogError("[Main] Error: ", err.Error())
+		stop(command)
+		logout.Flush()
+		os.Exit(1)
 	}
 
-	gameserver.GTempUserManager.Initialize(gameserver.USER_TEMP, 100)
-	gameserver.GUserManager.Initialize(gameserver.USER_NORMAL, 5000)
+	sigs := make(chan os.Signal, 1)
+	//signal.Ignore(os.Interrupt)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigs {
+		println("Signal -> ", sig.String())
+		if sig != syscall.SIGHUP {
+			break
+		}
+
+		// Reload log config (levels, formats, rotation)
+		if len(command.log_config) > 0 && !logout.LogLoadConfig(command.log_config) {
+			logout.LogError("[Main] Error: ", "reloading log config error.")
+		}
+
+		// Reload game server info
+		if command.gameserver && !gameserver.ReloadGameServer() {
+			logout.LogError("[GameServer] Error: ", "reloading game server error.")
+		}
+	}
 
-	if !server.InitHTTPServer("data/ServerInfo.json", gin.DebugMode) {
-		logout.LogError("[HTTP] Error: ", "init http server error.")
-		return
+	//
+	println("Exiting ...")
+
+	stop(command)
+	logout.Flush()
+
+	//
+	return
+}
+
+func start(command *t_command) error {
+	logout.Log("Command: ", command.name, ", Mode: ", command.mode)
+
+	logout.Log("Database (Redis) init ...")
+	if !database.RedisInitialize(command.server_config) {
+		return errors.New("initialize redis error")
 	}
 
-	if !server.StartHTTPServer() {
-		logout.LogError("[HTTP] Error: ", "starting http server error.")
-		return
+	if command.gameserver {
+		gameserver.GTempUserManager.Initialize(gameserver.USER_TEMP, 100)
+		gameserver.GUserManager.Initialize(gameserver.USER_NORMAL, 5000)
 	}
 
-	if !server.StartHTTPSServer() {
-		logout.LogError("[HTTP] Error: ", "starting https server error.")
-		return
+	if command.http || command.https {
+		if !server.InitHTTPServer(command.server_config, command.mode) {
+			return errors.New("init http server error")
+		}
 	}
 
-	logout.Log("Gameserver init ...")
-	if !gameserver.LoadGameServer("data/GameServerInfo.json") {
-		logout.LogError("[GameServer] Error: ", "loading game server error.")
-		return
+	if command.http && !server.StartHTTPServer() {
+		return errors.New("starting http server error (http port)")
 	}
 
-	sigs := make(chan os.Signal, 1)
-	//signal.Ignore(os.Interrupt)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-sigs
-	println("Signal -> %+v", sig)
+	if command.https && !server.StartHTTPSServer() {
+		return errors.New("starting https server error (https port, key, crt)")
+	}
 
-	//
-	println("Exiting ...")
+	if command.gameserver {
+		logout.Log("Gameserver init ...")
+		if !gameserver.LoadGameServer(command.gameserver_config) {
+			return errors.New("loading game server error")
+		}
+	}
 
-	gameserver.FreeGameServerAll()
+	// No http server in game process, metrics on own listener
+	if len(command.metrics) > 0 && !server.StartMetricsServer(command.metrics) {
+		return errors.New("starting metrics server error (metrics address)")
+	}
+	return nil
+}
 
-	gameserver.GTempUserManager.Release()
-	gameserver.GUserManager.Release()
+func stop(command *t_command) {
+	if command.gameserver {
+		gameserver.FreeGameServerAll()
 
-	database.RedisRelease()
+		gameserver.GTempUserManager.Release()
+		gameserver.GUserManager.Release()
+	}
 
-	//
-	return
+	database.RedisRelease()
 }
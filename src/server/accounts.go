@@ -0,0 +1,401 @@
+package server
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/gameserver"
+	"mcmcx.com/mserver/src/logout"
+	"mcmcx.com/mserver/src/util"
+)
+
+//
+const LOG_ACCOUNT = "ACCOUNT"
+
+// Account limits
+const (
+	ACCOUNT_PASSWORD_MINLEN = 8
+	ACCOUNT_PASSWORD_MAXLEN = 72 // bcrypt
+	ACCOUNT_CODES_MAX       = 8  // devices per account, oldest removed
+	ACCOUNT_IDX_RETRY       = 8
+	ACCOUNT_DEVICE_DEFAULT  = "default"
+)
+
+var account_name_regexp = regexp.MustCompile(`^[a-z][a-z0-9_]{3,31}$`)
+var account_device_regexp = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+
+// Compared when name not found, created on first use (bcrypt cost not paid at start)
+var account_dummy_hash []byte
+var account_dummy_once sync.Once
+
+func account_get_dummy_hash() []byte {
+	account_dummy_once.Do(func() {
+		account_dummy_hash, _ = bcrypt.GenerateFromPassword([]byte("account_dummy_password"), bcrypt.DefaultCost)
+	})
+	return account_dummy_hash
+}
+
+// API: register, account
+type RequestAccountData struct {
+	Name        string `form:"name"`
+	Password    string `form:"password"`
+	Device      string `form:"device"`       // optional, one code per device
+	NewPassword string `form:"new_password"` // password change
+}
+
+// Code and token for /auth
+type ResponseAccountData struct {
+	IDX      string `json:"idx"`
+	Name     string `json:"name"`
+	Code     string `json:"code"`
+	Token    string `json:"token"`
+	Status   string `json:"status"`
+	DateTime string `json:"date_time"`
+}
+
+type RequestAdminAccountStatus struct {
+	IDX    string `form:"idx"`
+	Status string `form:"status"` // active, suspended, closed
+	Reason string `form:"reason"`
+}
+
+//
+func account_validate(request *RequestAccountData) bool {
+	request.Name = strings.ToLower(strings.TrimSpace(request.Name))
+	request.Device = strings.TrimSpace(request.Device)
+	if len(request.Device) == 0 {
+		request.Device = ACCOUNT_DEVICE_DEFAULT
+	}
+
+	if !account_name_regexp.MatchString(request.Name) || !account_device_regexp.MatchString(request.Device) {
+		return false
+	}
+	return account_check_password(request.Password)
+}
+
+func account_check_password(password string) bool {
+	return len(password) >= ACCOUNT_PASSWORD_MINLEN && len(password) <= ACCOUNT_PASSWORD_MAXLEN
+}
+
+func account_hash_password(password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return ""
+	}
+	return string(hash)
+}
+
+// Account by name and password, nil if not found or password wrong
+func account_login(name string, password string) *database.DBAccount {
+	account := database.DB_get_account_by_name(name)
+	if account == nil {
+		// Same cost as a wrong password
+		bcrypt.CompareHashAndPassword(account_get_dummy_hash(), []byte(password))
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PassHash), []byte(password)) != nil {
+		return nil
+	}
+	return account
+}
+
+// New code of device (old code of device replaced), token kept
+func account_issue_code(auth_data *DBAuthData, device string) DBAuthDataSub {
+	if auth_data.List == nil {
+		auth_data.List = make(map[string]DBAuthDataSub)
+	}
+	for code, sub := range auth_data.List {
+		if sub.ID == device {
+			delete(auth_data.List, code)
+		}
+	}
+
+	// Oldest removed
+	if len(auth_data.List) >= ACCOUNT_CODES_MAX {
+		var subs []DBAuthDataSub
+		for _, sub := range auth_data.List {
+			subs = append(subs, sub)
+		}
+		sort.Slice(subs, func(i, j int) bool {
+			return subs[i].Timestamp < subs[j].Timestamp
+		})
+		for _, sub := range subs[:len(subs)-ACCOUNT_CODES_MAX+1] {
+			delete(auth_data.List, sub.Code)
+		}
+	}
+
+	var sub = DBAuthDataSub{
+		ID:        device,
+		Code:      util.GenerateAuthCode(4),
+		Timestamp: int64(util.GetTimeStamp64()),
+	}
+	for _, ok := auth_data.List[sub.Code]; ok; _, ok = auth_data.List[sub.Code] {
+		sub.Code = util.GenerateAuthCode(4)
+	}
+	auth_data.List[sub.Code] = sub
+	auth_data.Timestamp = sub.Timestamp
+	return sub
+}
+
+// Account status also in user data (auth refused if < 0)
+// Not active: user auth (token) revoked and sessions kicked
+func account_set_status(account *database.DBAccount, status int, reason string) bool {
+	account.Status = status
+	account.StatusReason = reason
+	account.StatusTime = int64(util.GetTimeStamp64())
+	if !database.DB_update_account(account) {
+		return false
+	}
+
+	if user_data := auth_store.GetUserData(account.IDX); user_data != nil {
+		user_data.Status = status
+		auth_store.UpdateUserData(user_data)
+	}
+	if status != database.ACCOUNT_STATUS_ACTIVE {
+		if _, ok := user_auth_revoke(account.IDX); !ok {
+			logout.LogWithName(LOG_ACCOUNT, "[ERROR] Account user auth revoke failed", logout.F("idx", account.IDX))
+		}
+	}
+
+	logout.LogWithName(LOG_ACCOUNT, "Account status", logout.F("idx", account.IDX),
+		logout.F("status", database.AccountStatusName(status)), logout.F("reason", reason))
+	return true
+}
+
+func account_result(account *database.DBAccount, auth_data *DBAuthData, code string) ResponseAccountData {
+	return ResponseAccountData{
+		IDX:      account.IDX,
+		Name:     account.Name,
+		Code:     code,
+		Token:    auth_data.Token,
+		Status:   database.AccountStatusName(account.Status),
+		DateTime: util.DateFormat(time.Now(), 3),
+	}
+}
+
+// 0: ok, -1: name exists, -3: internal error
+func U_account_register(request *RequestAccountData, result_data *ResponseAccountData) int {
+	if database.DB_exists_account_name(request.Name) {
+		return -1
+	}
+
+	var account = &database.DBAccount{
+		Name:      request.Name,
+		PassHash:  account_hash_password(request.Password),
+		Status:    database.ACCOUNT_STATUS_ACTIVE,
+		Timestamp: int64(util.GetTimeStamp64()),
+	}
+	if len(account.PassHash) == 0 {
+		return -3
+	}
+
+	// Unique idx
+	var created = false
+	for n := 0; n < ACCOUNT_IDX_RETRY && !created; n++ {
+		account.IDX = strconv.FormatInt(util.GenerateIDX(1), 10)
+		created = database.DB_create_account(account)
+	}
+	if !created {
+		return -3
+	}
+
+	// Name taken at the same time
+	if !database.DB_create_account_name(account.Name, account.IDX) {
+		database.DB_del_account(account.IDX)
+		if database.DB_exists_account_name(account.Name) {
+			return -1
+		}
+		return -3
+	}
+
+	// Auth data (user_<idx>) and user data (user_data_<idx>)
+	var auth_data = &DBAuthData{
+		IDX:   account.IDX,
+		Code:  util.RandomChars(6, 2),
+		Token: util.MD5(account.IDX + "_" + util.GenerateAuthCode(4) + "_" + util.GenerateTraceID()),
+	}
+	sub := account_issue_code(auth_data, request.Device)
+
+	var user_data = user_data_init(&RequestAuthData{IDX: account.IDX})
+	user_data.Status = database.ACCOUNT_STATUS_ACTIVE
+	if !DB_update_auth_data(auth_data) || !database.DB_update_user_data(account.IDX, user_data) {
+		return -3
+	}
+
+	*result_data = account_result(account, auth_data, sub.Code)
+	logout.LogWithName(LOG_ACCOUNT, "Account registered", logout.F("idx", account.IDX),
+		logout.F("name", account.Name), logout.F("device", request.Device))
+	return 0
+}
+
+//
+func R_handler_register(ctx *gin.Context) {
+	var request RequestAccountData
+	if ctx.ShouldBind(&request) != nil || !account_validate(&request) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	var result_data ResponseAccountData
+	switch U_account_register(&request, &result_data) {
+	case 0:
+		handler_result_data(ctx, result_data)
+	case -1:
+		handler_result_error_s(ctx, util.RESULT_ERROR_EXIST, "name exists")
+	default:
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+	}
+}
+
+// New code for device (login on device), active accounts only
+func R_handler_account_code(ctx *gin.Context) {
+	var request RequestAccountData
+	if ctx.ShouldBind(&request) != nil || !account_validate(&request) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	account := account_login(request.Name, request.Password)
+	if account == nil {
+		handler_result_ns(ctx, util.RESULT_FAILED, util.STATUS_FAILED)
+		return
+	}
+	if account.Status != database.ACCOUNT_STATUS_ACTIVE {
+		handler_result_error_s(ctx, util.RESULT_ERROR_DENIED, database.AccountStatusName(account.Status))
+		return
+	}
+
+	auth_data := DB_get_auth_data(account.IDX)
+	if auth_data == nil || auth_data.IDX != account.IDX {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
+	sub := account_issue_code(auth_data, request.Device)
+	if !DB_update_auth_data(auth_data) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
+
+	logout.LogWithName(LOG_ACCOUNT, "Account code", logout.F("idx", account.IDX), logout.F("device", request.Device))
+	handler_result_data(ctx, account_result(account, auth_data, sub.Code))
+}
+
+func R_handler_account_password(ctx *gin.Context) {
+	var request RequestAccountData
+	if ctx.ShouldBind(&request) != nil || !account_validate(&request) ||
+		!account_check_password(request.NewPassword) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	account := account_login(request.Name, request.Password)
+	if account == nil {
+		handler_result_ns(ctx, util.RESULT_FAILED, util.STATUS_FAILED)
+		return
+	}
+	if account.Status == database.ACCOUNT_STATUS_CLOSED {
+		handler_result_error_s(ctx, util.RESULT_ERROR_DENIED, database.AccountStatusName(account.Status))
+		return
+	}
+
+	account.PassHash = account_hash_password(request.NewPassword)
+	if len(account.PassHash) == 0 || !database.DB_update_account(account) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
+
+	logout.LogWithName(LOG_ACCOUNT, "Account password changed", logout.F("idx", account.IDX))
+	handler_result_null(ctx)
+}
+
+// Closed by owner, final
+func R_handler_account_close(ctx *gin.Context) {
+	var request RequestAccountData
+	if ctx.ShouldBind(&request) != nil || !account_validate(&request) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	account := account_login(request.Name, request.Password)
+	if account == nil {
+		handler_result_ns(ctx, util.RESULT_FAILED, util.STATUS_FAILED)
+		return
+	}
+	if !database.AccountStatusAllowed(account.Status, database.ACCOUNT_STATUS_CLOSED) {
+		handler_result_error_s(ctx, util.RESULT_ERROR_DENIED, database.AccountStatusName(account.Status))
+		return
+	}
+	if !account_set_status(account, database.ACCOUNT_STATUS_CLOSED, "closed by owner") {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
+	handler_result_null(ctx)
+}
+
+// ?idx= or ?name=
+func R_admin_accounts(ctx *gin.Context) {
+	var account *database.DBAccount
+	if idx := strings.TrimSpace(ctx.Query("idx")); len(idx) > 0 {
+		account = database.DB_get_account(idx)
+	} else if name := strings.TrimSpace(ctx.Query("name")); len(name) > 0 {
+		account = database.DB_get_account_by_name(name)
+	} else {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	if account == nil {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+
+	handler_result_data(ctx, gin.H{
+		"idx":           account.IDX,
+		"name":          account.Name,
+		"status":        database.AccountStatusName(account.Status),
+		"status_reason": account.StatusReason,
+		"status_time":   account.StatusTime,
+		"timestamp":     account.Timestamp,
+		"time_last":     account.TimeLast,
+		"sessions":      len(gameserver.GUserManager.FindUsersByIDX(account.IDX)),
+	})
+}
+
+func R_admin_account_status(ctx *gin.Context) {
+	var request RequestAdminAccountStatus
+	if ctx.ShouldBind(&request) != nil {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	status, ok := database.AccountStatusParse(request.Status)
+	if !ok {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	account := database.DB_get_account(strings.TrimSpace(request.IDX))
+	if account == nil {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+	if !database.AccountStatusAllowed(account.Status, status) {
+		handler_result_error_s(ctx, util.RESULT_ERROR_DENIED,
+			database.AccountStatusName(account.Status)+" -> "+database.AccountStatusName(status))
+		return
+	}
+	if !account_set_status(account, status, strings.TrimSpace(request.Reason)) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
+
+	handler_result_data(ctx, gin.H{
+		"idx":    account.IDX,
+		"status": database.AccountStatusName(account.Status),
+	})
+}
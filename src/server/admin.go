@@ -0,0 +1,303 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/gameserver"
+	"mcmcx.com/mserver/src/logout"
+	"mcmcx.com/mserver/src/util"
+)
+
+//
+const LOG_ADMIN = "ADMIN"
+
+// Default users limit
+const ADMIN_USERS_LIMIT = 100
+
+// API: admin
+type RequestAdminUsers struct {
+	Type   string `form:"type"` // user, temp, all
+	Search string `form:"search"`
+	Limit  int    `form:"limit"`
+}
+
+type RequestAdminKick struct {
+	IDX      string `form:"idx"`
+	ServerID int    `form:"server_id"`
+	SID      uint32 `form:"sid"`
+}
+
+type RequestAdminBroadcast struct {
+	ServerID int    `form:"server_id"` // 0: all
+	Message  string `form:"message"`
+}
+
+type RequestAdminMaintenance struct {
+	ServerID  int    `form:"server_id"`
+	Enable    bool   `form:"enable"`
+	Message   string `form:"message"`
+	Whitelist string `form:"whitelist"` // idx,idx (optional)
+}
+
+type RequestAdminServer struct {
+	ServerID int `form:"server_id"`
+}
+
+type RequestAdminLogLevel struct {
+	Name  string `form:"name"` // empty: all logs
+	Level string `form:"level"`
+}
+
+//
+func admin_enabled() bool {
+	for _, v := range server_info.AdminKeys {
+		if len(v) > 0 {
+			return true
+		}
+	}
+	return len(server_info.AdminCA) > 0
+}
+
+func register_admin_handlers(router *gin.Engine) bool {
+	if !admin_enabled() {
+		logout.LogWarn("[HTTP] Admin API closed (admin_keys, admin_ca)")
+		return false
+	}
+
+	admin := router.Group("/admin", R_admin_auth)
+	admin.GET("/servers", R_admin_servers)
+	admin.GET("/users", R_admin_users)
+	admin.POST("/kick", R_admin_kick)
+	admin.POST("/broadcast", R_admin_broadcast)
+	admin.POST("/maintenance", R_admin_maintenance)
+	admin.POST("/reload", R_admin_reload)
+	admin.POST("/token", R_admin_token)
+	admin.GET("/accounts", R_admin_accounts)
+	admin.POST("/accounts/status", R_admin_account_status)
+	admin.GET("/logs", R_admin_logs)
+	admin.POST("/logs/level", R_admin_log_level)
+	return true
+}
+
+// Client certificate verified by admin CA (HTTPS only)
+func admin_check_cert(ctx *gin.Context) bool {
+	if len(server_info.AdminCA) == 0 || ctx.Request.TLS == nil {
+		return false
+	}
+	return len(ctx.Request.TLS.VerifiedChains) > 0
+}
+
+// X-Admin-Key or Authorization: Bearer, HTTPS or loopback only (key not sent in clear over the network)
+func admin_check_key(ctx *gin.Context) bool {
+	if ctx.Request.TLS == nil && !admin_loopback(ctx) {
+		return false
+	}
+
+	key := strings.TrimSpace(ctx.GetHeader("X-Admin-Key"))
+	if len(key) == 0 {
+		key = strings.TrimSpace(strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer "))
+	}
+	if len(key) == 0 {
+		return false
+	}
+
+	for _, v := range server_info.AdminKeys {
+		if len(v) > 0 && subtle.ConstantTimeCompare([]byte(v), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Connection address (not X-Forwarded-For)
+func admin_loopback(ctx *gin.Context) bool {
+	ip := net.ParseIP(ctx.RemoteIP())
+	return ip != nil && ip.IsLoopback()
+}
+
+func R_admin_auth(ctx *gin.Context) {
+	if admin_check_cert(ctx) || admin_check_key(ctx) {
+		logout.LogWithName(LOG_ADMIN, "(", ctx.ClientIP(), ") ", ctx.Request.Method, " ", ctx.Request.URL.Path)
+		ctx.Next()
+		return
+	}
+
+	logout.LogWithName(LOG_ADMIN, "(", ctx.ClientIP(), ") Denied ", ctx.Request.Method, " ", ctx.Request.URL.Path)
+
+	data := l_init_data(ctx)
+	result := l_init_error_s(&data, util.RESULT_ERROR_INVALID, "unauthorized")
+	ctx.AbortWithStatusJSON(401, *result)
+}
+
+// ?registry=1 : nodes of all processes
+func R_admin_servers(ctx *gin.Context) {
+	var nodes []*database.DBServerNode
+	if ctx.Query("registry") == "1" {
+		nodes = database.DB_get_server_nodes()
+	} else {
+		nodes = gameserver.GetServerNodes()
+	}
+
+	// Tokens not listed
+	var list []database.DBServerNode
+	for _, v := range nodes {
+		node := *v
+		node.Token = ""
+		list = append(list, node)
+	}
+
+	handler_result_data(ctx, gin.H{
+		"list":  list,
+		"total": len(list),
+	})
+}
+
+func R_admin_users(ctx *gin.Context) {
+	var request RequestAdminUsers
+	if ctx.ShouldBind(&request) != nil {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	if request.Limit <= 0 {
+		request.Limit = ADMIN_USERS_LIMIT
+	}
+	request.Search = strings.TrimSpace(request.Search)
+
+	var list []gameserver.TUserStatus
+	switch request.Type {
+	case "", "user":
+		list = gameserver.GUserManager.Users(request.Search, request.Limit)
+	case "temp":
+		list = gameserver.GTempUserManager.Users(request.Search, request.Limit)
+	case "all":
+		list = gameserver.GUserManager.Users(request.Search, request.Limit)
+		if len(list) < request.Limit {
+			list = append(list, gameserver.GTempUserManager.Users(request.Search, request.Limit-len(list))...)
+		}
+	default:
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	handler_result_data(ctx, gin.H{
+		"list":      list,
+		"total":     len(list),
+		"users_num": gameserver.GUserManager.UserNum(),
+		"temps_num": gameserver.GTempUserManager.UserNum(),
+	})
+}
+
+// By idx (all sessions), or by server id and connection id
+// Game servers of this process only (login only process: none)
+func R_admin_kick(ctx *gin.Context) {
+	var request RequestAdminKick
+	if ctx.ShouldBind(&request) != nil {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	request.IDX = strings.TrimSpace(request.IDX)
+
+	var num = 0
+	if len(request.IDX) > 0 {
+		num = gameserver.KickUser(request.IDX)
+	} else if request.ServerID > 0 {
+		if gameserver.KickSession(request.ServerID, request.SID) {
+			num = 1
+		}
+	} else {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	if num == 0 {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+	handler_result_data(ctx, gin.H{"kicked": num})
+}
+
+// Game servers of this process only
+func R_admin_broadcast(ctx *gin.Context) {
+	var request RequestAdminBroadcast
+	if ctx.ShouldBind(&request) != nil || len(strings.TrimSpace(request.Message)) == 0 {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	num := gameserver.Broadcast(request.ServerID, request.Message)
+	handler_result_data(ctx, gin.H{"sessions": num})
+}
+
+// Game servers of this process only
+func R_admin_maintenance(ctx *gin.Context) {
+	var request RequestAdminMaintenance
+	if ctx.ShouldBind(&request) != nil || request.ServerID <= 0 {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+
+	if len(request.Whitelist) > 0 && !gameserver.SetServerWhitelist(request.ServerID,
+		strings.Split(request.Whitelist, ",")) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+	if !gameserver.SetServerMaintenance(request.ServerID, request.Enable, request.Message) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+
+	handler_result_data(ctx, gin.H{
+		"server_id":   request.ServerID,
+		"maintenance": request.Enable,
+	})
+}
+
+func R_admin_reload(ctx *gin.Context) {
+	if !gameserver.ReloadGameServer() {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
+	handler_result_null(ctx)
+}
+
+func R_admin_token(ctx *gin.Context) {
+	var request RequestAdminServer
+	if ctx.ShouldBind(&request) != nil || request.ServerID <= 0 {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	if !gameserver.RotateServerToken(request.ServerID) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+	handler_result_null(ctx)
+}
+
+func R_admin_logs(ctx *gin.Context) {
+	handler_result_data(ctx, gin.H{"levels": logout.LogLevels()})
+}
+
+// Until restart or log config reload (SIGHUP)
+func R_admin_log_level(ctx *gin.Context) {
+	var request RequestAdminLogLevel
+	if ctx.ShouldBind(&request) != nil {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	level, ok := logout.LogParseLevel(request.Level)
+	if !ok {
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+		return
+	}
+	if !logout.LogSetLevel(request.Name, level) {
+		handler_result_error_n(ctx, util.RESULT_ERROR_NOT_FOUND)
+		return
+	}
+
+	logout.LogWithName(LOG_ADMIN, "Log level: ", request.Name, " -> ", request.Level)
+	handler_result_data(ctx, gin.H{"levels": logout.LogLevels()})
+}
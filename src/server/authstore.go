@@ -0,0 +1,124 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/util"
+)
+
+// Records used by U_user_auth, U_auth_refresh and U_auth_logout
+type i_auth_store interface {
+	GetAuthData(idx string) *DBAuthData
+	GetUserAuth(idx string) *DBUserAuth
+	UpdateUserAuth(user_auth *DBUserAuth) bool
+	DeleteUserAuth(idx string) bool
+	GetUserData(idx string) *database.DBUserData
+	UpdateUserData(user_data *database.DBUserData) bool
+}
+
+var auth_store i_auth_store = &t_auth_store_redis{}
+
+// Redis (user_<idx>, user_auth_<idx>, user_data_<idx>)
+type t_auth_store_redis struct{}
+
+func (self *t_auth_store_redis) GetAuthData(idx string) *DBAuthData {
+	return DB_get_auth_data(idx)
+}
+
+func (self *t_auth_store_redis) GetUserAuth(idx string) *DBUserAuth {
+	return DB_get_user_auth(idx)
+}
+
+func (self *t_auth_store_redis) UpdateUserAuth(user_auth *DBUserAuth) bool {
+	return DB_update_user_auth(user_auth.IDX, user_auth)
+}
+
+func (self *t_auth_store_redis) DeleteUserAuth(idx string) bool {
+	return DB_del_user_auth(idx)
+}
+
+func (self *t_auth_store_redis) GetUserData(idx string) *database.DBUserData {
+	return database.DB_get_user_data(idx)
+}
+
+func (self *t_auth_store_redis) UpdateUserData(user_data *database.DBUserData) bool {
+	return database.DB_update_user_data(user_data.IDX, user_data)
+}
+
+// In process, tools and tests without Redis (copies stored)
+type t_auth_store_memory struct {
+	lock       sync.Mutex
+	auth_data  map[string]DBAuthData
+	user_auths map[string]DBUserAuth
+	user_datas map[string]database.DBUserData
+}
+
+func new_auth_store_memory() *t_auth_store_memory {
+	return &t_auth_store_memory{
+		auth_data:  make(map[string]DBAuthData),
+		user_auths: make(map[string]DBUserAuth),
+		user_datas: make(map[string]database.DBUserData),
+	}
+}
+
+func (self *t_auth_store_memory) SetAuthData(auth_data DBAuthData) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.auth_data[auth_data.IDX] = auth_data
+}
+
+func (self *t_auth_store_memory) GetAuthData(idx string) *DBAuthData {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	data, ok := self.auth_data[idx]
+	if !ok {
+		return nil
+	}
+	return &data
+}
+
+func (self *t_auth_store_memory) GetUserAuth(idx string) *DBUserAuth {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	data, ok := self.user_auths[idx]
+	if !ok {
+		return nil
+	}
+	user_auth_expired(&data)
+	return &data
+}
+
+func (self *t_auth_store_memory) UpdateUserAuth(user_auth *DBUserAuth) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	user_auth.TotalUsed++
+	user_auth.TimeLast = util.DateFormat(time.Now(), 3)
+	self.user_auths[user_auth.IDX] = *user_auth
+	return true
+}
+
+func (self *t_auth_store_memory) DeleteUserAuth(idx string) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.user_auths, idx)
+	return true
+}
+
+func (self *t_auth_store_memory) GetUserData(idx string) *database.DBUserData {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	data, ok := self.user_datas[idx]
+	if !ok {
+		return nil
+	}
+	return &data
+}
+
+func (self *t_auth_store_memory) UpdateUserData(user_data *database.DBUserData) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.user_datas[user_data.IDX] = *user_data
+	return true
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"mcmcx.com/mserver/src/logout"
 	"mcmcx.com/mserver/src/util"
 )
 
@@ -14,6 +15,8 @@ import (
 type TAuthToken struct {
 	IDX   string `form:"idx"`
 	Token string `form:"token"`
+	// Optional, response level (bound once with token)
+	RequestResultLevel
 }
 
 type t_auth_data struct {
@@ -24,6 +27,7 @@ type t_auth_data struct {
 	ip_client string
 	ip_remote string
 	result    int
+	level     RequestResultLevel
 }
 
 // API: auth
@@ -31,6 +35,9 @@ type RequestAuthData struct {
 	IDX   string `form:"idx"`
 	Code  string `form:"code"`
 	Token string `form:"token"`
+	// Optional, affinity balancing
+	Region string `form:"region"`
+	Tag    string `form:"tag"`
 }
 
 type ResponseAuthData struct {
@@ -40,6 +47,7 @@ type ResponseAuthData struct {
 	Token    string `json:"token"`     // API Token
 	PKey     string `json:"pkey"`      //PublicKey
 	PKeyHash string `json:"pkey_hash"` //PublicKey Hash
+	SignPKey string `json:"sign_pkey"` //Server PublicKey, sign of level 2 responses
 	// Server
 	ServerID      int    `json:"server_id"`
 	ServerName    string `json:"server_name"`
@@ -50,6 +58,35 @@ type ResponseAuthData struct {
 	ServerUserToken string `json:"server_user_token"`
 	// Time
 	DateTime string `json:"date_time"`
+	// Correlation with game auth (0x09) and logs
+	TraceID string `json:"trace_id"`
+}
+
+// API: auth/refresh, auth/logout
+type ResponseTokenData struct {
+	IDX      string  `json:"idx"`
+	Token    string  `json:"token,omitempty"`    // refresh: new API Token
+	Expired  float32 `json:"expired,omitempty"`  // refresh: seconds
+	Sessions int     `json:"sessions,omitempty"` // logout: game sessions closed
+	DateTime string  `json:"date_time"`
+}
+
+// Response level of authenticated API (idx, token)
+const (
+	RESULT_LEVEL_NONE = 0
+	RESULT_LEVEL_AES  = 1 // data encrypted
+	RESULT_LEVEL_SIGN = 2 // data encrypted and signed
+)
+
+// Client public key (ECC hex, as 0x09), shared key with user key of /auth pkey
+type RequestResultLevel struct {
+	Level int    `form:"level"`
+	PKey  string `form:"pkey"`
+}
+
+type t_result_level struct {
+	level int
+	key   []byte
 }
 
 // API: user
@@ -92,6 +129,7 @@ func l_init_auth(ctx *gin.Context) (int, *t_auth_data) {
 	//
 	auth_data.idx = auth_token.IDX
 	auth_data.token = auth_token.Token
+	auth_data.level = auth_token.RequestResultLevel
 
 	result := U_auth_token(&auth_data)
 	if result < 0 {
@@ -145,27 +183,73 @@ func l_init_result_sx(data *util.TMA, code int, status string, message string) *
 		case util.RESULT_ERROR_NOT_EXIST:
 			temp["result_status"] = util.STATUS_ERROR_NOT_EXIST
 			break
+		case util.RESULT_ERROR_EXIST:
+			temp["result_status"] = util.STATUS_ERROR_EXIST
+			break
+		case util.RESULT_ERROR_DENIED:
+			temp["result_status"] = util.STATUS_ERROR_DENIED
+			break
 		}
 	}
 
 	return util.MapConcatPtr(data, &temp)
 }
 
-func l_result_data(level int, data *util.TMA, result any) *util.TMA {
-	var encrypt_level = level
-	(*data)["level"] = encrypt_level
+// Client level (form level, pkey, bound by l_init_auth), nil: level 0
+func l_init_level(auth_data *t_auth_data) (*t_result_level, int) {
+	if auth_data == nil || auth_data.level.Level <= RESULT_LEVEL_NONE {
+		return nil, util.RESULT_OK
+	}
+	var level = auth_data.level.Level
+	if level > RESULT_LEVEL_SIGN {
+		level = RESULT_LEVEL_SIGN
+	}
+
+	key, result := user_result_key(auth_data.idx, strings.TrimSpace(auth_data.level.PKey))
+	if result != util.RESULT_OK {
+		return nil, result
+	}
+	return &t_result_level{
+		level: level,
+		key:   key,
+	}, util.RESULT_OK
+}
+
+// level 1: data AES (GCM) encrypted, base64
+// level 2: and sign of data (ECC, server sign key, sign_pkey of /auth)
+// Applied level in envelope, false if encryption or sign failed (not sent)
+func l_result_data(level *t_result_level, data *util.TMA, result any) (*util.TMA, bool) {
+	(*data)["level"] = RESULT_LEVEL_NONE
 
-	if encrypt_level == 0 || result == nil {
+	if level == nil || level.level <= RESULT_LEVEL_NONE || result == nil {
 		(*data)["data"] = result
-	} else {
-		(*data)["data"] = ""
-		buffer, err := json.Marshal(result)
-		if err == nil {
-			temp := base64.URLEncoding.EncodeToString(buffer)
-			(*data)["data"] = temp
+		return data, true
+	}
+
+	buffer, err := json.Marshal(result)
+	if err != nil {
+		return data, false
+	}
+	buffer, err = util.AESGCMEncrypt(buffer, level.key)
+	if err != nil {
+		return data, false
+	}
+	var text = base64.StdEncoding.EncodeToString(buffer)
+	(*data)["data"] = text
+	(*data)["level"] = RESULT_LEVEL_AES
+
+	if level.level >= RESULT_LEVEL_SIGN {
+		if server_sign_key == nil {
+			return data, false
 		}
+		sign, err := util.ECCSignDataEncoding([]byte(text), server_sign_key)
+		if err != nil {
+			return data, false
+		}
+		(*data)["sign"] = sign
+		(*data)["level"] = RESULT_LEVEL_SIGN
 	}
-	return data
+	return data, true
 }
 
 func handler_result_error(ctx *gin.Context, err error) {
@@ -195,16 +279,25 @@ func handler_result_null(ctx *gin.Context) {
 
 	data := l_init_data(ctx)
 	result := l_init_result_s(&data, util.RESULT_OK, util.STATUS_OK)
-	result = l_result_data(0, result, nil)
+	result, _ = l_result_data(nil, result, nil)
 
 	ctx.JSON(200, *result)
 }
 
 func handler_result_data(ctx *gin.Context, result_data any) {
+	handler_result_data_level(ctx, nil, result_data)
+}
+
+func handler_result_data_level(ctx *gin.Context, level *t_result_level, result_data any) {
 
 	data := l_init_data(ctx)
 	result := l_init_result_s(&data, util.RESULT_OK, util.STATUS_OK)
-	result = l_result_data(0, result, result_data)
+	result, ok := l_result_data(level, result, result_data)
+	if !ok {
+		logout.LogWithName(LOG_HTTP, "[ERROR] Response level failed", logout.F("level", level.level))
+		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
+		return
+	}
 
 	ctx.JSON(200, *result)
 }
@@ -217,6 +310,14 @@ func handler_result_ns(ctx *gin.Context, code int, status string) {
 	ctx.JSON(200, *result)
 }
 
+// New trace id of request, in access log (context key) and response header
+func handler_trace_id(ctx *gin.Context) string {
+	trace_id := util.GenerateTraceID()
+	ctx.Set(TRACE_ID_KEY, trace_id)
+	ctx.Header("X-Trace-Id", trace_id)
+	return trace_id
+}
+
 //
 func R_handler_ping(ctx *gin.Context) {
 	ctx.JSON(200, gin.H{
@@ -235,6 +336,7 @@ func R_handler_hello(ctx *gin.Context) {
 func R_handler_auth(ctx *gin.Context) {
 	var auth_data RequestAuthData
 	if ctx.ShouldBind(&auth_data) != nil {
+		metrics_auth(METRICS_AUTH_INVALID)
 		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
 		return
 	}
@@ -243,22 +345,103 @@ func R_handler_auth(ctx *gin.Context) {
 	auth_data.IDX = strings.TrimSpace(auth_data.IDX)
 	auth_data.Code = strings.ToLower(strings.TrimSpace(auth_data.Code))
 	auth_data.Token = strings.ToUpper(strings.TrimSpace(auth_data.Token))
+	auth_data.Region = strings.TrimSpace(auth_data.Region)
+	auth_data.Tag = strings.TrimSpace(auth_data.Tag)
 	if len(auth_data.IDX) < 6 || len(auth_data.Code) < 6 ||
 		(len(auth_data.Token) != 16 && len(auth_data.Token) != 32) {
+		metrics_auth(METRICS_AUTH_INVALID)
 		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
 		return
 	}
 
 	var result_data ResponseAuthData
+	result_data.TraceID = handler_trace_id(ctx)
 	var result = U_user_auth(&auth_data, &result_data)
 	if result < 0 {
-		handler_result_ns(ctx, util.RESULT_FAILED, util.STATUS_FAILED)
+		logout.LogWithName(LOG_HTTP, "[AUTH] Authentication failed", logout.F("trace_id", result_data.TraceID),
+			logout.F("idx", auth_data.IDX), logout.F("result", result), logout.F("address", ctx.ClientIP()))
+
+		switch result {
+		case util.RESULT_ERROR_INTERNAL:
+			metrics_auth(METRICS_AUTH_ERROR)
+			handler_result_error_n(ctx, result)
+		case util.RESULT_ERROR_INVALID:
+			metrics_auth(METRICS_AUTH_INVALID)
+			handler_result_error_n(ctx, result)
+		default:
+			// Failed, not error: wrong token, unknown code, expired, disabled
+			metrics_auth(METRICS_AUTH_FAILED)
+			handler_result_ns(ctx, result, util.STATUS_FAILED)
+		}
 		return
 	}
 
+	if result_data.ServerID > 0 {
+		metrics_auth(METRICS_AUTH_OK)
+	} else {
+		metrics_auth(METRICS_AUTH_NO_SERVER)
+	}
+	logout.LogWithName(LOG_HTTP, "[AUTH] Authentication successed", logout.F("trace_id", result_data.TraceID),
+		logout.F("idx", auth_data.IDX), logout.F("server_id", result_data.ServerID), logout.F("address", ctx.ClientIP()))
 	handler_result_data(ctx, result_data)
 }
 
+// Valid token (not expired) required
+func R_handler_auth_refresh(ctx *gin.Context) {
+	res, auth := l_init_auth(ctx)
+	if res <= 0 {
+		handler_auth_refused(ctx, res, auth)
+		return
+	}
+	level, result := l_init_level(auth)
+	if result != util.RESULT_OK {
+		handler_result_error_n(ctx, result)
+		return
+	}
+
+	var result_data ResponseTokenData
+	if result = U_auth_refresh(auth, &result_data); result < 0 {
+		handler_result_error_n(ctx, result)
+		return
+	}
+	logout.LogWithName(LOG_HTTP, "[AUTH] Token refreshed", logout.F("idx", auth.idx), logout.F("address", ctx.ClientIP()))
+	handler_result_data_level(ctx, level, result_data)
+}
+
+func R_handler_auth_logout(ctx *gin.Context) {
+	res, auth := l_init_auth(ctx)
+	if res <= 0 {
+		handler_auth_refused(ctx, res, auth)
+		return
+	}
+	level, result := l_init_level(auth)
+	if result != util.RESULT_OK {
+		handler_result_error_n(ctx, result)
+		return
+	}
+
+	var result_data ResponseTokenData
+	if result = U_auth_logout(auth, &result_data); result < 0 {
+		handler_result_error_n(ctx, result)
+		return
+	}
+	logout.LogWithName(LOG_HTTP, "[AUTH] Token revoked", logout.F("idx", auth.idx),
+		logout.F("sessions", result_data.Sessions), logout.F("address", ctx.ClientIP()))
+	handler_result_data_level(ctx, level, result_data)
+}
+
+// l_init_auth result: -2 expired, 0 wrong token, -1 error
+func handler_auth_refused(ctx *gin.Context, res int, auth *t_auth_data) {
+	switch {
+	case auth != nil && auth.result == -2:
+		handler_result_ns(ctx, util.RESULT_AUTH_EXPIRED, util.STATUS_FAILED)
+	case res == 0:
+		handler_result_ns(ctx, util.RESULT_AUTH_TOKEN, util.STATUS_FAILED)
+	default:
+		handler_result_error_n(ctx, util.RESULT_ERROR_INVALID)
+	}
+}
+
 func R_handler_user(ctx *gin.Context) {
 	// Error or failed
 	res, auth := l_init_auth(ctx)
@@ -266,14 +449,19 @@ func R_handler_user(ctx *gin.Context) {
 		handler_result_error_n(ctx, util.RESULT_ERROR_INTERNAL)
 		return
 	}
+	level, result := l_init_level(auth)
+	if result != util.RESULT_OK {
+		handler_result_error_n(ctx, result)
+		return
+	}
 
 	var result_data ResponseUserData
-	result := U_user_data(auth, &result_data)
+	result = U_user_data(auth, &result_data)
 	if result < 0 {
 		handler_result_ns(ctx, util.RESULT_FAILED, util.STATUS_FAILED)
 		return
 	}
 
 	// data
-	handler_result_data(ctx, result_data)
+	handler_result_data_level(ctx, level, result_data)
 }
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mredis "mcmcx.com/mserver/modules/redis"
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/gameserver"
+	"mcmcx.com/mserver/src/util"
+)
+
+// Listener status
+const (
+	LISTENER_CLOSED   = 0 // not started (not configured or disabled)
+	LISTENER_STARTING = 1
+	LISTENER_WORKING  = 2
+	LISTENER_ERROR    = -1
+)
+
+// Check status
+const (
+	CHECK_OK       = "ok"
+	CHECK_FAILED   = "failed"
+	CHECK_DISABLED = "disabled" // not counted
+)
+
+// Redis ping timeout
+const READY_REDIS_TIMEOUT = 2 * time.Second
+
+//
+type t_listener_status struct {
+	lock   sync.Mutex
+	status int
+	err    error
+}
+
+func (self *t_listener_status) set(status int, err error) {
+	self.lock.Lock()
+	self.status = status
+	self.err = err
+	self.lock.Unlock()
+}
+
+func (self *t_listener_status) get() (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.status, self.err
+}
+
+var http_listener t_listener_status
+var https_listener t_listener_status
+
+var start_time = time.Now()
+
+//
+type TCheckResult struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	Message string  `json:"message,omitempty"`
+}
+
+func check_run(fn func() (string, string)) TCheckResult {
+	var start = time.Now()
+	status, message := fn()
+	return TCheckResult{
+		Status:  status,
+		Latency: float64(time.Since(start).Microseconds()) * 0.001,
+		Message: message,
+	}
+}
+
+func check_redis() (string, string) {
+	if err := mredis.Ping(READY_REDIS_TIMEOUT); err != nil {
+		return CHECK_FAILED, err.Error()
+	}
+	return CHECK_OK, ""
+}
+
+// Registry nodes (separate game nodes), or local servers
+func check_gameserver() (string, string) {
+	var nodes []*database.DBServerNode
+	if server_info.Registry {
+		nodes = database.DB_get_server_nodes()
+	} else {
+		nodes = gameserver.GetServerNodes()
+	}
+
+	for _, v := range nodes {
+		if v.Status == gameserver.STATUS_WORKING {
+			return CHECK_OK, ""
+		}
+	}
+	return CHECK_FAILED, "no working game server"
+}
+
+func check_listener(listener *t_listener_status) func() (string, string) {
+	return func() (string, string) {
+		status, err := listener.get()
+		switch status {
+		case LISTENER_CLOSED:
+			return CHECK_DISABLED, ""
+		case LISTENER_WORKING:
+			return CHECK_OK, ""
+		case LISTENER_STARTING:
+			return CHECK_FAILED, "starting"
+		}
+		if err != nil {
+			return CHECK_FAILED, err.Error()
+		}
+		return CHECK_FAILED, "error"
+	}
+}
+
+// Liveness: process is responding
+func R_handler_healthz(ctx *gin.Context) {
+	ctx.JSON(200, gin.H{
+		"status":   CHECK_OK,
+		"uptime":   int64(time.Since(start_time).Seconds()),
+		"time_utc": util.DateFormat(time.Now().UTC(), 9),
+	})
+}
+
+// Readiness: all dependencies ok, 503 otherwise
+func R_handler_readyz(ctx *gin.Context) {
+	var checks = map[string]TCheckResult{
+		"redis":      check_run(check_redis),
+		"gameserver": check_run(check_gameserver),
+		"http":       check_run(check_listener(&http_listener)),
+		"https":      check_run(check_listener(&https_listener)),
+	}
+
+	var status = CHECK_OK
+	for _, v := range checks {
+		if v.Status == CHECK_FAILED {
+			status = CHECK_FAILED
+		}
+	}
+
+	var code = 200
+	if status != CHECK_OK {
+		code = 503
+	}
+	ctx.JSON(code, gin.H{
+		"status":   status,
+		"checks":   checks,
+		"time_utc": util.DateFormat(time.Now().UTC(), 9),
+	})
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	mredis "mcmcx.com/mserver/modules/redis"
+	"mcmcx.com/mserver/modules/zinx/ziface"
+	"mcmcx.com/mserver/src/gameserver"
+	"mcmcx.com/mserver/src/logout"
+)
+
+// Prometheus text exposition format (version 0.0.4)
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// /auth outcomes
+const (
+	METRICS_AUTH_OK        = "ok"
+	METRICS_AUTH_NO_SERVER = "no_server" // ok, no game server assigned
+	METRICS_AUTH_INVALID   = "invalid"
+	METRICS_AUTH_FAILED    = "failed"
+	METRICS_AUTH_ERROR     = "error"
+)
+
+var metrics_auth_lock sync.Mutex
+var metrics_auth_list = make(map[string]uint64)
+
+func metrics_auth(result string) {
+	metrics_auth_lock.Lock()
+	metrics_auth_list[result]++
+	metrics_auth_lock.Unlock()
+}
+
+//
+type t_metrics struct {
+	builder strings.Builder
+}
+
+func (self *t_metrics) head(name string, kind string, help string) {
+	fmt.Fprintf(&self.builder, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (self *t_metrics) value(name string, labels string, value any) {
+	if len(labels) > 0 {
+		fmt.Fprintf(&self.builder, "%s{%s} %v\n", name, labels, value)
+		return
+	}
+	fmt.Fprintf(&self.builder, "%s %v\n", name, value)
+}
+
+// buckets are cumulative counts for each upper bound
+func (self *t_metrics) histogram(name string, labels string, bounds []float64, buckets []uint64,
+	sum float64, count uint64) {
+	var prefix = labels
+	if len(prefix) > 0 {
+		prefix += ","
+	}
+	for n, le := range bounds {
+		self.value(name+"_bucket", prefix+"le=\""+strconv.FormatFloat(le, 'g', -1, 64)+"\"", buckets[n])
+	}
+	self.value(name+"_bucket", prefix+"le=\"+Inf\"", count)
+	self.value(name+"_sum", labels, strconv.FormatFloat(sum, 'g', -1, 64))
+	self.value(name+"_count", labels, count)
+}
+
+func metrics_label(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
+
+func server_labels(id int, name string) string {
+	return fmt.Sprintf("server_id=\"%d\",name=\"%s\"", id, metrics_label(name))
+}
+
+func message_labels(id int, stat *ziface.TMsgStat) string {
+	if stat.ID == ziface.MsgIDUnknown {
+		return fmt.Sprintf("server_id=\"%d\",msg_id=\"unknown\"", id)
+	}
+	return fmt.Sprintf("server_id=\"%d\",msg_id=\"0x%02x\"", id, stat.ID)
+}
+
+func metrics_text() string {
+	var metrics t_metrics
+	var servers = gameserver.GetServerStats()
+
+	// Game servers
+	metrics.head("mserver_gameserver_sessions", "gauge", "Connected sessions per game server.")
+	for _, v := range servers {
+		metrics.value("mserver_gameserver_sessions", server_labels(v.Node.ID, v.Node.Name), v.Node.SessionsNum)
+	}
+	metrics.head("mserver_gameserver_sessions_max", "gauge", "Max sessions per game server.")
+	for _, v := range servers {
+		metrics.value("mserver_gameserver_sessions_max", server_labels(v.Node.ID, v.Node.Name), v.Node.SessionsMaxNum)
+	}
+	metrics.head("mserver_worker_queue_depth", "gauge", "Messages waiting in worker queues.")
+	for _, v := range servers {
+		metrics.value("mserver_worker_queue_depth", server_labels(v.Node.ID, v.Node.Name), v.QueueLen)
+	}
+
+	// Users
+	metrics.head("mserver_users", "gauge", "Users by type (temp: not authenticated).")
+	metrics.value("mserver_users", "type=\"temp\"", gameserver.GTempUserManager.UserNum())
+	metrics.value("mserver_users", "type=\"user\"", gameserver.GUserManager.UserNum())
+
+	// Messages
+	metrics.head("mserver_messages_received_total", "counter", "Messages received per message ID.")
+	for _, v := range servers {
+		for n := range v.Messages {
+			metrics.value("mserver_messages_received_total", message_labels(v.Node.ID, &v.Messages[n]), v.Messages[n].In)
+		}
+	}
+	metrics.head("mserver_messages_sent_total", "counter", "Messages sent per message ID.")
+	for _, v := range servers {
+		for n := range v.Messages {
+			metrics.value("mserver_messages_sent_total", message_labels(v.Node.ID, &v.Messages[n]), v.Messages[n].Out)
+		}
+	}
+	metrics.head("mserver_message_handler_seconds", "histogram", "Message handler latency per message ID.")
+	for _, v := range servers {
+		for n := range v.Messages {
+			stat := &v.Messages[n]
+			if stat.Count == 0 {
+				continue
+			}
+			metrics.histogram("mserver_message_handler_seconds", message_labels(v.Node.ID, stat),
+				ziface.MsgLatencyBuckets, stat.Buckets, stat.Sum, stat.Count)
+		}
+	}
+
+	// Auth
+	metrics_auth_lock.Lock()
+	var results []string
+	for k := range metrics_auth_list {
+		results = append(results, k)
+	}
+	sort.Strings(results)
+	metrics.head("mserver_auth_requests_total", "counter", "/auth requests by result.")
+	for _, k := range results {
+		metrics.value("mserver_auth_requests_total", "result=\""+k+"\"", metrics_auth_list[k])
+	}
+	metrics_auth_lock.Unlock()
+
+	// Redis
+	var commands = mredis.Stats()
+	metrics.head("mserver_redis_command_seconds", "histogram", "Redis command latency.")
+	for n := range commands {
+		stat := &commands[n]
+		metrics.histogram("mserver_redis_command_seconds", "command=\""+metrics_label(stat.Name)+"\"",
+			mredis.LatencyBuckets, stat.Buckets, stat.Sum, stat.Count)
+	}
+	metrics.head("mserver_redis_command_errors_total", "counter", "Redis command errors.")
+	for n := range commands {
+		metrics.value("mserver_redis_command_errors_total", "command=\""+metrics_label(commands[n].Name)+"\"",
+			commands[n].Errors)
+	}
+
+	return metrics.builder.String()
+}
+
+//
+func R_handler_metrics(ctx *gin.Context) {
+	ctx.Data(200, METRICS_CONTENT_TYPE, []byte(metrics_text()))
+}
+
+// Game only process (no HTTP server): /metrics on its own listener
+func StartMetricsServer(address string) bool {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logout.LogError("[Metrics] Error: ", err.Error())
+		return false
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+		writer.Write([]byte(metrics_text()))
+	})
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logout.LogError("[Metrics] Error: ", err.Error())
+		}
+	}()
+	logout.Log("[Metrics] Server starting on ", address)
+	return true
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/gameserver"
 	"mcmcx.com/mserver/src/logout"
 	"mcmcx.com/mserver/src/util"
 )
@@ -16,14 +22,38 @@ type ServerInfo struct {
 	HttpsPort int    `json:"https"`
 	HttpsKey  string `json:"https_key"`
 	HttpsCrt  string `json:"https_crt"`
+
+	// Choose game server from redis registry (separate game nodes)
+	Registry bool `json:"registry"`
+	// Load balancing strategy (default, least, weighted, hash, affinity)
+	Balance string `json:"balance"`
+
+	// Admin API (/admin): keys (X-Admin-Key), or client certificates signed by CA (HTTPS)
+	AdminKeys []string `json:"admin_keys"`
+	AdminCA   string   `json:"admin_ca"`
+
+	// Development only (debug mode): failed credential checks of /auth logged, not refused
+	AuthRelaxed bool `json:"auth_relaxed"`
+
+	// Ticket signing key file (login nodes only, copied to each login node), created if missing
+	// Empty: key in Redis (server_ticket_key), readable by game nodes using the same Redis
+	TicketKey string `json:"ticket_key"`
 }
 
 //
 const LOG_HTTP = "HTTP"
 
+// Context key of trace id (access log)
+const TRACE_ID_KEY = "trace_id"
+
 //
 var router_instance *gin.Engine = nil
 var server_info ServerInfo
+var server_balance gameserver.IBalance
+var server_auth_relaxed = false
+
+// Sign of level 2 responses, shared by login nodes (Redis server_sign_key)
+var server_sign_key *ecdsa.PrivateKey
 
 //
 func load_serverinfo(filename string) bool {
@@ -33,6 +63,20 @@ func load_serverinfo(filename string) bool {
 		logout.LogError("[Load] Read server info fail")
 		return false
 	}
+
+	var ok bool
+	server_balance, ok = gameserver.NewBalance(server_info.Balance)
+	if !ok {
+		logout.LogError("[Load] Unknown balance: ", server_info.Balance)
+		return false
+	}
+
+	server_auth_relaxed = server_info.AuthRelaxed && gin.Mode() == gin.DebugMode
+	if server_auth_relaxed {
+		logout.LogWarn("[Load] Auth relaxed (development only), credential checks not enforced")
+	} else if server_info.AuthRelaxed {
+		logout.LogWarn("[Load] Auth relaxed ignored, not in debug mode")
+	}
 	return true
 }
 
@@ -40,7 +84,18 @@ func register_handlers(router *gin.Engine) bool {
 	router.GET("/ping", R_handler_ping)
 	router.GET("/hello", R_handler_hello)
 	router.Any("/auth", R_handler_auth)
+	router.POST("/auth/refresh", R_handler_auth_refresh)
+	router.POST("/auth/logout", R_handler_auth_logout)
 	router.GET("/user", R_handler_user)
+	router.POST("/register", R_handler_register)
+	router.POST("/account/code", R_handler_account_code)
+	router.POST("/account/password", R_handler_account_password)
+	router.POST("/account/close", R_handler_account_close)
+	router.GET("/metrics", R_handler_metrics)
+	router.GET("/healthz", R_handler_healthz)
+	router.GET("/readyz", R_handler_readyz)
+
+	register_admin_handlers(router)
 	return true
 }
 
@@ -52,9 +107,19 @@ func InitHTTPServer(filename string, mode string) bool {
 	router_instance = gin.Default()
 
 	logout.LogAdd(logout.LogLevel_Info, LOG_HTTP, true, false)
+	logout.LogAdd(logout.LogLevel_Info, LOG_ADMIN, true, true)
+	logout.LogAdd(logout.LogLevel_Info, LOG_ACCOUNT, true, false)
 	if !load_serverinfo(filename) {
 		return false
 	}
+	if !gameserver.LoadTicketSignKey(server_info.TicketKey) {
+		logout.LogError("[Load] Load ticket signing key fail")
+		return false
+	}
+	if server_sign_key = database.DB_load_server_key(database.DB_KEY_SIGN); server_sign_key == nil {
+		logout.LogError("[Load] Load response signing key fail")
+		return false
+	}
 
 	// custom logs
 	router_instance.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
@@ -70,6 +135,9 @@ func InitHTTPServer(filename string, mode string) bool {
 			//param.Request.UserAgent(),
 			//param.ErrorMessage,
 		)
+		if trace_id, ok := param.Keys[TRACE_ID_KEY]; ok {
+			text += fmt.Sprintf(" trace_id=%v", trace_id)
+		}
 		logout.LogWithName(LOG_HTTP, text)
 		if len(param.ErrorMessage) > 0 {
 			logout.LogWithName(LOG_HTTP, "Error Message : ", param.ErrorMessage)
@@ -85,8 +153,17 @@ func InitHTTPServer(filename string, mode string) bool {
 func start_http_server(address string) bool {
 	//IPv6
 	//router.Run(":8080") // listen and serve on 0.0.0.0:8080
-	var err = http.ListenAndServe(address, router_instance)
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
+		http_listener.set(LISTENER_ERROR, err)
+		logout.LogError("[HTTP] Error: ", err.Error(), "")
+		return false
+	}
+	http_listener.set(LISTENER_WORKING, nil)
+
+	err = http.Serve(listener, router_instance)
+	if err != nil {
+		http_listener.set(LISTENER_ERROR, err)
 		logout.LogError("[HTTP] Error: ", err.Error(), "")
 		return false
 	}
@@ -94,10 +171,36 @@ func start_http_server(address string) bool {
 }
 
 func start_https_server(address string, key string, crt string) bool {
-	var err = http.ListenAndServeTLS(address,
-		crt, key,
-		router_instance)
+	var server = &http.Server{
+		Addr:    address,
+		Handler: router_instance,
+	}
+
+	// Admin client certificates (optional for other routes)
+	if len(server_info.AdminCA) > 0 {
+		pool := util.LoadCertCAFromFile(server_info.AdminCA)
+		if pool == nil {
+			logout.LogError("[HTTPS] Error: ", "load admin CA (", server_info.AdminCA, ")")
+			https_listener.set(LISTENER_ERROR, errors.New("load admin CA failed"))
+			return false
+		}
+		server.TLSConfig = &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  pool,
+		}
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		https_listener.set(LISTENER_ERROR, err)
+		logout.LogError("[HTTPS] Error: ", err.Error(), "")
+		return false
+	}
+	https_listener.set(LISTENER_WORKING, nil)
+
+	err = server.ServeTLS(listener, crt, key)
 	if err != nil {
+		https_listener.set(LISTENER_ERROR, err)
 		logout.LogError("[HTTPS] Error: ", err.Error(), "")
 		return false
 	}
@@ -114,6 +217,7 @@ func StartHTTPServer() bool {
 		return false
 	}
 
+	http_listener.set(LISTENER_STARTING, nil)
 	go start_http_server(fmt.Sprintf(":%d", port))
 	defer logout.Log("[HTTP] Server starting on ", port)
 	return true
@@ -130,6 +234,7 @@ func StartHTTPSServer() bool {
 		return false
 	}
 
+	https_listener.set(LISTENER_STARTING, nil)
 	go start_https_server(fmt.Sprintf(":%d", port),
 		server_info.HttpsKey, server_info.HttpsCrt)
 	defer logout.Log("[HTTPS] Server starting on ", port)
@@ -1,12 +1,14 @@
 package server
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"crypto/subtle"
 	"time"
 
 	mredis "mcmcx.com/mserver/modules/redis"
 	"mcmcx.com/mserver/src/database"
 	"mcmcx.com/mserver/src/gameserver"
+	"mcmcx.com/mserver/src/logout"
 	"mcmcx.com/mserver/src/util"
 )
 
@@ -44,15 +46,27 @@ func DB_get_auth_data(idx string) *DBAuthData {
 	var data DBAuthData
 	result := mredis.GetJson[DBAuthData]("user_"+idx, &data)
 	if !result {
-		// return nil
+		return nil
 	}
 	// idx same,
 	if idx != data.IDX {
-		//return nil
+		return nil
 	}
 	return &data
 }
 
+func DB_update_auth_data(auth_data *DBAuthData) bool {
+	if auth_data == nil {
+		return false
+	}
+
+	result := mredis.PushJson[DBAuthData]("user_"+auth_data.IDX, auth_data, util.TIME_KEEPN)
+	if !result {
+		return false
+	}
+	return true
+}
+
 func DB_get_user_auth(idx string) *DBUserAuth {
 	var data DBUserAuth
 	result := mredis.GetJson[DBUserAuth]("user_auth_"+idx, &data)
@@ -64,12 +78,16 @@ func DB_get_user_auth(idx string) *DBUserAuth {
 		return nil
 	}
 
-	// Expired Time set status : -1
+	user_auth_expired(&data)
+	return &data
+}
+
+// Expired Time set status : -1
+func user_auth_expired(data *DBUserAuth) {
 	data.Expired = util.ExpiredTimestamp64(uint64(data.Timestamp), util.TIME_DAY)
 	if data.Expired <= 0 {
 		data.Status = -1
 	}
-	return &data
 }
 
 func DB_update_user_auth(idx string, user_auth *DBUserAuth) bool {
@@ -88,6 +106,10 @@ func DB_update_user_auth(idx string, user_auth *DBUserAuth) bool {
 	return true
 }
 
+func DB_del_user_auth(idx string) bool {
+	return mredis.DelWithKey("user_auth_" + idx)
+}
+
 //
 func user_auth_init(auth_data *RequestAuthData) *DBUserAuth {
 
@@ -97,7 +119,7 @@ func user_auth_init(auth_data *RequestAuthData) *DBUserAuth {
 	var token = util.SHA256(auth_data.Code + "_" + code + "_" + rand)
 
 	// Get user auth
-	var db_user_auth = DB_get_user_auth(auth_data.IDX)
+	var db_user_auth = auth_store.GetUserAuth(auth_data.IDX)
 	if db_user_auth == nil || db_user_auth.Status < 0 {
 		db_user_auth = &DBUserAuth{
 			IDX:       auth_data.IDX,
@@ -159,80 +181,106 @@ func U_auth_token(auth_data *t_auth_data) int {
 		return -1 // error
 	}
 
-	var db_user_auth = DB_get_user_auth(auth_data.idx)
-	if db_user_auth == nil || db_user_auth.Status < 0 {
+	var db_user_auth = auth_store.GetUserAuth(auth_data.idx)
+	if db_user_auth == nil {
 		return -1 // internal error
 	}
+	// Expired before status (status -1 when expired)
 	if db_user_auth.Expired <= 0 {
 		auth_data.result = -2
 		return -2 // expired time
 	}
-	if db_user_auth.Token != auth_data.token {
+	if db_user_auth.Status < 0 {
+		return -1 // internal error
+	}
+	if subtle.ConstantTimeCompare([]byte(db_user_auth.Token), []byte(auth_data.token)) != 1 {
 		auth_data.result = 0
 		return 0 // failed, not error
 	}
 
-	DB_update_user_auth(auth_data.idx, db_user_auth)
+	auth_store.UpdateUserAuth(db_user_auth)
 	auth_data.result = 1
 	return 1 //ok
 }
 
-//
-func U_user_auth(auth_data *RequestAuthData, result_data *ResponseAuthData) int {
-	if len(auth_data.IDX) < 10 || len(auth_data.Code) != 8 || len(auth_data.Token) != 32 {
-		return -1
-	}
-
-	db_auth_data := DB_get_auth_data(auth_data.IDX)
+// Checks of U_user_auth, failed checks only logged if relaxed (debug mode, auth_relaxed)
+func user_auth_check(auth_data *RequestAuthData, db_auth_data *DBAuthData) int {
 	if db_auth_data == nil {
-		return -1
+		return util.RESULT_AUTH_TOKEN
 	}
-
-	if db_auth_data.Token != auth_data.Token {
-		//return -1
+	if subtle.ConstantTimeCompare([]byte(db_auth_data.Token), []byte(auth_data.Token)) != 1 {
+		return util.RESULT_AUTH_TOKEN
 	}
 
-	//
-	result_data.IDX = auth_data.IDX
-	result_data.DateTime = util.DateFormat(time.Now(), 3)
-
-	//
 	sub, ok := db_auth_data.List[auth_data.Code]
 	if !ok || sub.Code != auth_data.Code {
-		sub = DBAuthDataSub{}
-		//return -1
+		return util.RESULT_AUTH_CODE
 	}
 
-	// time expired
+	// Auth data (last code) and code of device expire, new code by /account/code
 	if util.ExpiredTimestamp64(uint64(db_auth_data.Timestamp), util.TIME_DAY) <= 0 ||
 		util.ExpiredTimestamp64(uint64(sub.Timestamp), util.TIME_DAY) <= 0 {
-		//return -2 // expired time
+		return util.RESULT_AUTH_EXPIRED
 	}
+	return util.RESULT_OK
+}
+
+// util.RESULT_OK, RESULT_ERROR_INVALID, RESULT_ERROR_INTERNAL,
+// RESULT_AUTH_TOKEN (wrong token), RESULT_AUTH_CODE (unknown code), RESULT_AUTH_EXPIRED, RESULT_AUTH_DISABLED
+func U_user_auth(auth_data *RequestAuthData, result_data *ResponseAuthData) int {
+	if len(auth_data.IDX) < 10 || len(auth_data.Code) != 8 || len(auth_data.Token) != 32 {
+		return util.RESULT_ERROR_INVALID
+	}
+
+	if result := user_auth_check(auth_data, auth_store.GetAuthData(auth_data.IDX)); result != util.RESULT_OK {
+		if !server_auth_relaxed {
+			return result
+		}
+		logout.LogWithName(LOG_HTTP, "[AUTH] (Relaxed) Credential check failed", logout.F("idx", auth_data.IDX),
+			logout.F("result", result), logout.F("trace_id", result_data.TraceID))
+	}
+
+	//
+	result_data.IDX = auth_data.IDX
+	result_data.DateTime = util.DateFormat(time.Now(), 3)
 
 	var db_user_auth = user_auth_init(auth_data)
 	if db_user_auth == nil {
-		return -3 // internal error
+		return util.RESULT_ERROR_INTERNAL
 	}
 
 	//
-	var db_user_data = database.DB_get_user_data(auth_data.IDX)
-	if db_user_data == nil || db_user_data.Status < 0 {
-		return -3 // internal error
+	var db_user_data = auth_store.GetUserData(auth_data.IDX)
+	if db_user_data == nil {
+		return util.RESULT_ERROR_INTERNAL
+	}
+	// Suspended or closed account
+	if db_user_data.Status < 0 {
+		return util.RESULT_AUTH_DISABLED
+	}
+
+	// User key (user data of register or /auth)
+	pkey := util.ECCX509PrivateKeyDecoding(db_user_data.PKey)
+	if pkey == nil {
+		return util.RESULT_ERROR_INTERNAL
 	}
 
 	// Update auth time
 	db_user_data.AuthTime = int64(util.GetTimeStamp64())
+	db_user_data.TraceID = result_data.TraceID
 
 	//
-	DB_update_user_auth(db_user_auth.IDX, db_user_auth)
+	auth_store.UpdateUserAuth(db_user_auth)
 
 	// Result data
-	pkey := util.ECCX509PrivateKeyDecoding(db_user_data.PKey)
 
 	result_data.Code = db_user_auth.Code
 	result_data.Token = db_user_auth.Token
 	result_data.PKey = util.ECCPublicKeyEncoding(&pkey.PublicKey)
 	result_data.PKeyHash = util.MD5(result_data.PKey)
+	if server_sign_key != nil {
+		result_data.SignPKey = util.ECCPublicKeyEncoding(&server_sign_key.PublicKey)
+	}
 
 	// Server data
 	db_user_data.ServerID = 0
@@ -246,37 +294,121 @@ func U_user_auth(auth_data *RequestAuthData, result_data *ResponseAuthData) int
 	result_data.ServerPort = 0
 	result_data.ServerUserToken = ""
 
-	var server = gameserver.GServerManager.GetIdleServer()
-	if server != nil {
-		var server_info = gameserver.GServerManager.GetServerInfo(server.ID)
+	var node = gameserver.GetIdleServerNode(server_info.Registry, server_balance, &gameserver.TBalanceRequest{
+		IDX:    auth_data.IDX,
+		Region: auth_data.Region,
+		Tag:    auth_data.Tag,
+	})
+	if node != nil {
+		db_user_data.ServerID = node.ID
+		db_user_data.ServerName = node.Title
+		db_user_data.ServerToken = node.Token
+
+		result_data.ServerID = node.ID
+		result_data.ServerToken = node.Token
+		result_data.ServerName = node.Title
+		result_data.ServerAddress = node.Address
+		result_data.ServerPort = node.Port
+
+		// Signed ticket, verified by game server (0x09) without user data
+		ticket, ok := gameserver.IssueTicket(result_data.IDX, node.ID, result_data.TraceID)
+		if !ok {
+			return util.RESULT_ERROR_INTERNAL
+		}
+		db_user_data.ServerUserToken = ticket
 
-		db_user_data.ServerID = server.ID
-		db_user_data.ServerToken = server.Token
+		result_data.ServerUserToken = db_user_data.ServerUserToken
+	}
 
-		result_data.ServerID = server.ID
-		result_data.ServerToken = server.Token
-		result_data.ServerName = ""
-		result_data.ServerAddress = "127.0.0.1"
-		result_data.ServerPort = 9000
-		if server_info != nil {
-			db_user_data.ServerName = server_info.Title
+	auth_store.UpdateUserData(db_user_data)
 
-			result_data.ServerName = server_info.Title
-			result_data.ServerAddress = server_info.GateAddress
-			result_data.ServerPort = server_info.GatePort
-		}
+	//
+	return util.RESULT_OK
+}
 
-		var text = fmt.Sprintf("%d_%s_%s_%s", result_data.ServerID, result_data.ServerToken,
-			result_data.IDX, result_data.Code)
-		db_user_data.ServerUserToken = util.MD5(text)
+// New token of the valid user auth (old token refused), expired time restarts
+// util.RESULT_OK, RESULT_ERROR_INVALID, RESULT_ERROR_INTERNAL
+func U_auth_refresh(auth_data *t_auth_data, result_data *ResponseTokenData) int {
+	if auth_data == nil {
+		return util.RESULT_ERROR_INVALID
+	}
 
-		result_data.ServerUserToken = db_user_data.ServerUserToken
+	var db_user_auth = auth_store.GetUserAuth(auth_data.idx)
+	if db_user_auth == nil || db_user_auth.Status < 0 {
+		return util.RESULT_ERROR_INTERNAL
 	}
 
-	database.DB_update_user_data(db_user_data.IDX, db_user_data)
+	var rand = util.GenerateAuthCode(0)
+	db_user_auth.Token = util.SHA256(db_user_auth.Code + "_" + util.GenerateAuthCode(4) + "_" + rand)
+	db_user_auth.Timestamp = int64(util.GetTimeStamp64())
+	db_user_auth.TotalUsed = 0
+	if !auth_store.UpdateUserAuth(db_user_auth) {
+		return util.RESULT_ERROR_INTERNAL
+	}
 
-	//
-	return 0
+	result_data.IDX = db_user_auth.IDX
+	result_data.Token = db_user_auth.Token
+	result_data.Expired = util.TIME_DAY
+	result_data.DateTime = util.DateFormat(time.Now(), 3)
+	return util.RESULT_OK
+}
+
+// Token removed, server user token cleared (game auth refused) and game sessions closed
+// util.RESULT_OK, RESULT_ERROR_INVALID, RESULT_ERROR_INTERNAL
+func U_auth_logout(auth_data *t_auth_data, result_data *ResponseTokenData) int {
+	if auth_data == nil {
+		return util.RESULT_ERROR_INVALID
+	}
+
+	sessions, ok := user_auth_revoke(auth_data.idx)
+	if !ok {
+		return util.RESULT_ERROR_INTERNAL
+	}
+
+	result_data.IDX = auth_data.idx
+	result_data.Sessions = sessions
+	result_data.DateTime = util.DateFormat(time.Now(), 3)
+	return util.RESULT_OK
+}
+
+// Logout, account suspended or closed: token removed, server cleared,
+// tickets refused and sessions kicked on all game processes
+func user_auth_revoke(idx string) (int, bool) {
+	if !auth_store.DeleteUserAuth(idx) {
+		return 0, false
+	}
+
+	if db_user_data := auth_store.GetUserData(idx); db_user_data != nil {
+		db_user_data.ServerID = 0
+		db_user_data.ServerName = ""
+		db_user_data.ServerToken = ""
+		db_user_data.ServerUserToken = ""
+		auth_store.UpdateUserData(db_user_data)
+	}
+
+	return gameserver.PublishRevokeUser(idx), true
+}
+
+// Response key (level >= 1) of user
+// Shared key of user key (/auth pkey) and client key
+func user_result_key(idx string, pkey string) ([]byte, int) {
+	var client_pkey = util.ECCPublicKeyDecoding(pkey)
+	if client_pkey == nil {
+		return nil, util.RESULT_ERROR_INVALID
+	}
+
+	var db_user_data = auth_store.GetUserData(idx)
+	if db_user_data == nil || db_user_data.Status < 0 {
+		return nil, util.RESULT_ERROR_INTERNAL
+	}
+	var skey = util.ECCX509PrivateKeyDecoding(db_user_data.PKey)
+	if skey == nil {
+		return nil, util.RESULT_ERROR_INTERNAL
+	}
+
+	var shared = util.ECCGenSharedKey(skey, client_pkey)
+	var key = sha256.Sum256(append(shared, []byte("response")...))
+	return key[:], util.RESULT_OK
 }
 
 func U_user_data(auth_data *t_auth_data, result_data *ResponseUserData) int {
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"testing"
+
+	"mcmcx.com/mserver/src/database"
+	"mcmcx.com/mserver/src/util"
+)
+
+const test_idx = "1000000001"
+const test_code = "abcd1234"
+const test_token = "0123456789abcdef0123456789abcdef"
+
+// Memory store with auth data (code of device) and user data (key), restored by returned func
+func test_auth_store(t *testing.T, timestamp int64) (*t_auth_store_memory, func()) {
+	store := new_auth_store_memory()
+	store.SetAuthData(DBAuthData{
+		IDX:       test_idx,
+		Token:     test_token,
+		Timestamp: timestamp,
+		List: map[string]DBAuthDataSub{
+			test_code: {ID: ACCOUNT_DEVICE_DEFAULT, Code: test_code, Timestamp: timestamp},
+		},
+	})
+
+	skey, _, err := util.ECCGenkey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.UpdateUserData(&database.DBUserData{
+		IDX:      test_idx,
+		PKey:     util.ECCX509PrivateKeyEncoding(skey),
+		AuthTime: int64(util.GetTimeStamp64()),
+	})
+
+	prev_store, prev_relaxed := auth_store, server_auth_relaxed
+	auth_store = store
+	server_auth_relaxed = false
+	return store, func() {
+		auth_store = prev_store
+		server_auth_relaxed = prev_relaxed
+	}
+}
+
+func test_user_auth(code string, token string) (int, *ResponseAuthData) {
+	var result_data ResponseAuthData
+	result := U_user_auth(&RequestAuthData{IDX: test_idx, Code: code, Token: token}, &result_data)
+	return result, &result_data
+}
+
+func TestUserAuth(t *testing.T) {
+	store, restore := test_auth_store(t, int64(util.GetTimeStamp64()))
+	defer restore()
+
+	result, result_data := test_user_auth(test_code, test_token)
+	if result != util.RESULT_OK {
+		t.Fatalf("auth: result %d", result)
+	}
+	if result_data.IDX != test_idx || len(result_data.Token) == 0 || len(result_data.PKey) == 0 {
+		t.Fatalf("auth: result data %+v", result_data)
+	}
+
+	// Token of /auth for the API (U_auth_token)
+	user_auth := store.GetUserAuth(test_idx)
+	if user_auth == nil || user_auth.Token != result_data.Token {
+		t.Fatal("auth: user auth not stored")
+	}
+	if U_auth_token(&t_auth_data{idx: test_idx, token: result_data.Token}) != 1 {
+		t.Fatal("auth token: refused")
+	}
+	if U_auth_token(&t_auth_data{idx: test_idx, token: test_token}) != 0 {
+		t.Fatal("auth token: wrong token accepted")
+	}
+}
+
+func TestUserAuthWrongToken(t *testing.T) {
+	_, restore := test_auth_store(t, int64(util.GetTimeStamp64()))
+	defer restore()
+
+	if result, _ := test_user_auth(test_code, "ffffffffffffffffffffffffffffffff"); result != util.RESULT_AUTH_TOKEN {
+		t.Fatalf("wrong token: result %d", result)
+	}
+}
+
+func TestUserAuthUnknownCode(t *testing.T) {
+	_, restore := test_auth_store(t, int64(util.GetTimeStamp64()))
+	defer restore()
+
+	if result, _ := test_user_auth("zzzz9999", test_token); result != util.RESULT_AUTH_CODE {
+		t.Fatalf("unknown code: result %d", result)
+	}
+}
+
+func TestUserAuthExpired(t *testing.T) {
+	// Code issued two days ago
+	_, restore := test_auth_store(t, int64(util.GetTimeStamp64())-2*util.TIME_DAY*1000)
+	defer restore()
+
+	if result, _ := test_user_auth(test_code, test_token); result != util.RESULT_AUTH_EXPIRED {
+		t.Fatalf("expired code: result %d", result)
+	}
+}
+
+func TestUserAuthExpiredToken(t *testing.T) {
+	store, restore := test_auth_store(t, int64(util.GetTimeStamp64()))
+	defer restore()
+
+	store.UpdateUserAuth(&DBUserAuth{
+		IDX:       test_idx,
+		Token:     test_token,
+		Timestamp: int64(util.GetTimeStamp64()) - 2*util.TIME_DAY*1000,
+	})
+	if result := U_auth_token(&t_auth_data{idx: test_idx, token: test_token}); result != -2 {
+		t.Fatalf("expired token: result %d", result)
+	}
+}
+
+func TestUserAuthRelaxed(t *testing.T) {
+	_, restore := test_auth_store(t, int64(util.GetTimeStamp64()))
+	defer restore()
+
+	// Failed checks only logged
+	server_auth_relaxed = true
+	if result, _ := test_user_auth("zzzz9999", "ffffffffffffffffffffffffffffffff"); result != util.RESULT_OK {
+		t.Fatalf("relaxed: result %d", result)
+	}
+
+	server_auth_relaxed = false
+	if result, _ := test_user_auth("zzzz9999", "ffffffffffffffffffffffffffffffff"); result == util.RESULT_OK {
+		t.Fatal("not relaxed: accepted")
+	}
+}
+
+func TestUserAuthNoKey(t *testing.T) {
+	store, restore := test_auth_store(t, int64(util.GetTimeStamp64()))
+	defer restore()
+
+	store.UpdateUserData(&database.DBUserData{IDX: test_idx})
+	if result, _ := test_user_auth(test_code, test_token); result != util.RESULT_ERROR_INTERNAL {
+		t.Fatalf("no key: result %d", result)
+	}
+}
@@ -15,9 +15,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"hash"
+	"io"
 	"math/big"
 	"strings"
 
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/text/encoding/unicode"
 )
 
@@ -36,6 +38,31 @@ const AES_IV_128 = "0123456789012345"
 // AES-256-IV
 const AES_IV_256 = "01234567890123456789012345678901"
 
+// ECIES (ECCEncrypt) format version, HKDF info
+const ECIES_VERSION = 0x01
+const ECIES_INFO = "mserver-ecies-v1"
+const ECIES_PUBKEY_LEN = 65                           // uncompressed P-256
+const ECIES_OVERHEAD = 1 + ECIES_PUBKEY_LEN + 12 + 16 // version, key, nonce, tag
+
+// Trace (correlation) ID, 32 lowercase hex
+const TRACE_ID_LEN = 32
+
+func GenerateTraceID() string {
+	var data = make([]byte, TRACE_ID_LEN/2)
+	if _, err := rand.Read(data); err != nil {
+		return strings.ToLower(MD5(GenerateAuthCode(4) + "_" + GenerateAuthCode(1)))
+	}
+	return hex.EncodeToString(data)
+}
+
+func CheckTraceID(trace_id string) bool {
+	if len(trace_id) != TRACE_ID_LEN {
+		return false
+	}
+	_, err := hex.DecodeString(trace_id)
+	return err == nil
+}
+
 //
 func MD5(text string) string {
 	hash := md5.New()
@@ -267,7 +294,7 @@ func ECCSign(hash []byte, key *ecdsa.PrivateKey) ([]byte, error) {
 	data.WriteByte('S')
 	data.WriteByte(uint8(len(rx)))
 	data.Write(rx)
-	data.WriteByte(uint8(len(rx)))
+	data.WriteByte(uint8(len(sx)))
 	data.Write(sx)
 	return data.Bytes(), nil
 }
@@ -339,11 +366,108 @@ func ECCVerifyDataDecoding(data []byte, sign string, key *ecdsa.PublicKey) (int,
 	return ECCVerifyData(data, sign_data, key)
 }
 
-func ECCEncrypt(pubkey *ecdsa.PublicKey) {
+// ECIES: ephemeral P-256 ECDH, HKDF-SHA256, AES-256-GCM
+// version (1) + ephemeral public key (65, as ECCPublicKeyData) + nonce (12) + ciphertext + tag (16)
+func ECCEncrypt(data []byte, pubkey *ecdsa.PublicKey) ([]byte, error) {
+	if pubkey == nil || pubkey.Curve != ECCCurve256() || !pubkey.Curve.IsOnCurve(pubkey.X, pubkey.Y) {
+		return nil, errors.New("ECC public key error")
+	}
+
+	ekey, _, err := ECCGenkey()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, 1+ECIES_PUBKEY_LEN)
+	header = append(header, ECIES_VERSION)
+	header = append(header, ECCPublicKeyData(&ekey.PublicKey)...)
+
+	key, err := ecies_key(ekey, pubkey, header[1:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := ecies_gcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// Own array, additional data (header) must not overlap dst
+	buffer := make([]byte, 0, ECIES_OVERHEAD+len(data))
+	buffer = append(buffer, header...)
+	buffer = append(buffer, nonce...)
+	return gcm.Seal(buffer, nonce, data, header), nil
+}
+
+func ECCDecrypt(buffer []byte, prikey *ecdsa.PrivateKey) ([]byte, error) {
+	if prikey == nil || prikey.Curve != ECCCurve256() {
+		return nil, errors.New("ECC private key error")
+	}
+	if len(buffer) < ECIES_OVERHEAD {
+		return nil, errors.New("ECIES data length error")
+	}
+	if buffer[0] != ECIES_VERSION {
+		return nil, errors.New("ECIES version error")
+	}
 
+	header := buffer[:1+ECIES_PUBKEY_LEN]
+	epub := ECCPublicKeyParseData(header[1:])
+	if epub == nil {
+		return nil, errors.New("ECIES public key error")
+	}
+
+	key, err := ecies_key(prikey, epub, header[1:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := ecies_gcm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := buffer[len(header) : len(header)+gcm.NonceSize()]
+	return gcm.Open(nil, nonce, buffer[len(header)+gcm.NonceSize():], header)
 }
 
-func ECCDecrypt(prikey *ecdsa.PrivateKey) {
+func ECCEncryptString(data []byte, pubkey *ecdsa.PublicKey) (string, error) {
+	buffer, err := ECCEncrypt(data, pubkey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buffer), nil
+}
+
+func ECCDecryptString(text string, prikey *ecdsa.PrivateKey) ([]byte, error) {
+	buffer, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, err
+	}
+	return ECCDecrypt(buffer, prikey)
+}
+
+// Shared x (32 bytes), salt: ephemeral public key
+func ecies_key(prikey *ecdsa.PrivateKey, pubkey *ecdsa.PublicKey, salt []byte) ([]byte, error) {
+	x, _ := prikey.Curve.ScalarMult(pubkey.X, pubkey.Y, prikey.D.Bytes())
+	if x == nil || x.Sign() == 0 {
+		return nil, errors.New("ECDH shared key error")
+	}
+	secret := x.FillBytes(make([]byte, 32))
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(ECIES_INFO)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func ecies_gcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 //
@@ -445,3 +569,39 @@ func AESDecryptStringUTF8(text string, key []byte) (string, error) {
 
 	return string(data), nil
 }
+
+//AES加密,GCM (nonce + ciphertext), key 16, 24 or 32 bytes
+func AESGCMEncrypt(data, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+//AES解密,GCM
+func AESGCMDecrypt(buffer, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buffer) < gcm.NonceSize() {
+		return nil, errors.New("AES GCM data length error")
+	}
+	nonce := buffer[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, buffer[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func test_genkey(t *testing.T) *ecdsa.PrivateKey {
+	skey, _, err := ECCGenkey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return skey
+}
+
+func TestECIESRoundTrip(t *testing.T) {
+	skey := test_genkey(t)
+	// Public key of client (hex, ECCPublicKeyEncoding)
+	pkey := ECCPublicKeyDecoding(ECCPublicKeyEncoding(&skey.PublicKey))
+	if pkey == nil {
+		t.Fatal("public key decoding")
+	}
+
+	for _, data := range [][]byte{[]byte("secret data"), {}, bytes.Repeat([]byte{0xAB}, 4096)} {
+		buffer, err := ECCEncrypt(data, pkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buffer) != ECIES_OVERHEAD+len(data) || buffer[0] != ECIES_VERSION {
+			t.Fatalf("ciphertext format: length %d", len(buffer))
+		}
+		result, err := ECCDecrypt(buffer, skey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result, data) {
+			t.Fatal("round trip: data changed")
+		}
+	}
+
+	text, err := ECCEncryptString([]byte("secret text"), pkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := ECCDecryptString(text, skey); err != nil || string(result) != "secret text" {
+		t.Fatalf("string round trip: %v", err)
+	}
+}
+
+func TestECIESTampered(t *testing.T) {
+	skey := test_genkey(t)
+	data := []byte("secret data")
+	buffer, err := ECCEncrypt(data, &skey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nonce = 1 + ECIES_PUBKEY_LEN
+	var ciphertext = nonce + 12
+	var tag = len(buffer) - 1
+	for name, offset := range map[string]int{
+		"version":    0,
+		"ephemeral":  1 + ECIES_PUBKEY_LEN/2,
+		"nonce":      nonce,
+		"ciphertext": ciphertext,
+		"tag":        tag,
+	} {
+		tampered := append([]byte{}, buffer...)
+		tampered[offset] ^= 0x01
+		if _, err := ECCDecrypt(tampered, skey); err == nil {
+			t.Fatalf("tampered %s: accepted", name)
+		}
+	}
+
+	if _, err := ECCDecrypt(buffer, test_genkey(t)); err == nil {
+		t.Fatal("wrong private key: accepted")
+	}
+	if _, err := ECCDecrypt(buffer[:ECIES_OVERHEAD-1], skey); err == nil {
+		t.Fatal("short buffer: accepted")
+	}
+}
+
+func TestECIESPublicKey(t *testing.T) {
+	skey := test_genkey(t)
+
+	// Off curve
+	off_curve := &ecdsa.PublicKey{Curve: ECCCurve256(), X: new(big.Int).Set(skey.X), Y: new(big.Int).Add(skey.Y, big.NewInt(1))}
+	if _, err := ECCEncrypt([]byte("data"), off_curve); err == nil {
+		t.Fatal("off curve key: accepted")
+	}
+
+	// Not P-256
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ECCEncrypt([]byte("data"), &p384.PublicKey); err == nil {
+		t.Fatal("P-384 key: accepted")
+	}
+	if _, err := ECCEncrypt([]byte("data"), nil); err == nil {
+		t.Fatal("nil key: accepted")
+	}
+}
+
+// Signature with len(r) != len(s): length of s written after r
+func TestECCSignLength(t *testing.T) {
+	skey := test_genkey(t)
+	data := []byte("signed data")
+	for i := 0; i < 4096; i++ {
+		sign, err := ECCSignData(data, skey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rl := int(sign[2])
+		sl := int(sign[3+rl])
+		if rl == sl {
+			continue
+		}
+		if len(sign) != 4+rl+sl {
+			t.Fatalf("sign length %d, r %d, s %d", len(sign), rl, sl)
+		}
+		if result, err := ECCVerifyData(data, sign, &skey.PublicKey); result != 0 {
+			t.Fatalf("verify len(r)=%d len(s)=%d: %v", rl, sl, err)
+		}
+		return
+	}
+	t.Skip("no signature with len(r) != len(s)")
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Server ticket: base64url(payload) "." base64url(ECC sign)
+// payload: version.idx.server_id.expires.nonce.trace_id (trace_id optional)
+const TICKET_VERSION = "2"
+const TICKET_NONCE_LEN = 32
+
+// Signed by login server (/auth), verified by game server (0x09)
+type TTicket struct {
+	IDX      string
+	ServerID int
+	Expires  int64 // timestamp64
+	Nonce    string
+	TraceID  string // HTTP auth correlation
+}
+
+func GenerateTicketNonce() string {
+	var data = make([]byte, TICKET_NONCE_LEN/2)
+	if _, err := rand.Read(data); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(data)
+}
+
+func TicketEncode(ticket *TTicket, key *ecdsa.PrivateKey) (string, error) {
+	if ticket == nil || key == nil {
+		return "", errors.New("ticket or key null")
+	}
+	if len(ticket.IDX) == 0 || strings.Contains(ticket.IDX, ".") || len(ticket.Nonce) != TICKET_NONCE_LEN ||
+		strings.Contains(ticket.TraceID, ".") {
+		return "", errors.New("ticket data error")
+	}
+
+	var payload = fmt.Sprintf("%s.%s.%d.%d.%s.%s", TICKET_VERSION, ticket.IDX, ticket.ServerID, ticket.Expires,
+		ticket.Nonce, ticket.TraceID)
+	sign, err := ECCSignData([]byte(payload), key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sign), nil
+}
+
+// Format and sign only, expires and nonce checked by caller
+// 0: ok
+// -1: sign failed
+// -2: format error
+func TicketDecode(text string, key *ecdsa.PublicKey) (*TTicket, int) {
+	if key == nil {
+		return nil, -2
+	}
+	parts := strings.Split(text, ".")
+	if len(parts) != 2 {
+		return nil, -2
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, -2
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, -2
+	}
+
+	fields := strings.Split(string(payload), ".")
+	if len(fields) != 6 || fields[0] != TICKET_VERSION || len(fields[4]) != TICKET_NONCE_LEN {
+		return nil, -2
+	}
+	server_id, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, -2
+	}
+	expires, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, -2
+	}
+
+	if result, _ := ECCVerifyData(payload, sign, key); result != 0 {
+		return nil, result
+	}
+	return &TTicket{
+		IDX:      fields[1],
+		ServerID: server_id,
+		Expires:  expires,
+		Nonce:    fields[4],
+		TraceID:  fields[5],
+	}, 0
+}
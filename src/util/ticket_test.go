@@ -0,0 +1,93 @@
+package util
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func test_ticket() *TTicket {
+	return &TTicket{IDX: "10001", ServerID: 3, Expires: 1700000000000, Nonce: GenerateTicketNonce(), TraceID: "trace-1"}
+}
+
+func TestTicketRoundTrip(t *testing.T) {
+	skey := test_genkey(t)
+	ticket := test_ticket()
+	text, err := TicketEncode(ticket, skey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, code := TicketDecode(text, &skey.PublicKey)
+	if code != 0 {
+		t.Fatalf("decode: %d", code)
+	}
+	if *result != *ticket {
+		t.Fatalf("round trip: %+v != %+v", result, ticket)
+	}
+
+	// Wrong public key
+	if _, code := TicketDecode(text, &test_genkey(t).PublicKey); code != -1 {
+		t.Fatalf("wrong key: %d", code)
+	}
+}
+
+func TestTicketTampered(t *testing.T) {
+	skey := test_genkey(t)
+	text, err := TicketEncode(test_ticket(), skey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(text, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	sign, _ := base64.RawURLEncoding.DecodeString(parts[1])
+
+	// Payload: other IDX, signature unchanged
+	tampered := strings.Replace(string(payload), ".10001.", ".10002.", 1)
+	if _, code := TicketDecode(base64.RawURLEncoding.EncodeToString([]byte(tampered))+"."+parts[1], &skey.PublicKey); code != -1 {
+		t.Fatalf("tampered payload: %d", code)
+	}
+
+	// Signature: last byte of s
+	sign[len(sign)-1] ^= 0x01
+	if _, code := TicketDecode(parts[0]+"."+base64.RawURLEncoding.EncodeToString(sign), &skey.PublicKey); code != -1 {
+		t.Fatalf("tampered signature: %d", code)
+	}
+
+	// Format
+	for _, text := range []string{"", parts[0], parts[0] + ".!" + parts[1], text + ".x"} {
+		if _, code := TicketDecode(text, &skey.PublicKey); code != -2 {
+			t.Fatalf("format %q: %d", text, code)
+		}
+	}
+	if _, code := TicketDecode(text, nil); code != -2 {
+		t.Fatalf("nil key: %d", code)
+	}
+}
+
+func TestTicketNonce(t *testing.T) {
+	skey := test_genkey(t)
+	if len(GenerateTicketNonce()) != TICKET_NONCE_LEN {
+		t.Fatal("nonce length")
+	}
+
+	ticket := test_ticket()
+	ticket.Nonce = ticket.Nonce[1:]
+	if _, err := TicketEncode(ticket, skey); err == nil {
+		t.Fatal("encode short nonce: accepted")
+	}
+	ticket.Nonce += "00"
+	if _, err := TicketEncode(ticket, skey); err == nil {
+		t.Fatal("encode long nonce: accepted")
+	}
+
+	// Signed payload with short nonce
+	payload := "2.10001.3.1700000000000.abcdef.trace-1"
+	sign, err := ECCSignData([]byte(payload), skey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sign)
+	if _, code := TicketDecode(text, &skey.PublicKey); code != -2 {
+		t.Fatalf("decode short nonce: %d", code)
+	}
+}
@@ -28,6 +28,12 @@ const (
 
 // ERROR
 const (
+	RESULT_AUTH_DISABLED   = -14 // account suspended or closed
+	RESULT_AUTH_EXPIRED    = -13 // code expired
+	RESULT_AUTH_CODE       = -12 // unknown code
+	RESULT_AUTH_TOKEN      = -11 // wrong token
+	RESULT_ERROR_DENIED    = -9
+	RESULT_ERROR_EXIST     = -8
 	RESULT_ERROR_NOT_EXIST = -7
 	RESULT_ERROR_NOT_FOUND = -6
 	RESULT_ERROR_INTERNAL  = -3
@@ -37,6 +43,8 @@ const (
 	RESULT_SUCCESSED       = 0
 	RESULT_OK              = 0
 
+	STATUS_ERROR_DENIED    = "ERROR_DENIED"
+	STATUS_ERROR_EXIST     = "ERROR_EXIST"
 	STATUS_ERROR_NOT_EXIST = "ERROR_NOT_EXIST"
 	STATUS_ERROR_NOT_FOUND = "ERROR_NOT_FOUND"
 	STATUS_ERROR_INTERNAL  = "ERROR_INTERNAL"
@@ -127,11 +135,12 @@ func RandomInit() uint32 {
 
 func RandomNumber() uint32 {
 	var value = RandomInit()
-	var a = uint32(rand.Intn(0x0FFF))
-	var b = uint32(rand.Intn(0x0FFF))
-	var c = uint32(rand.Intn(0x0FFF))
-	var d = uint32(rand.Intn(0x0FFF))
-	var r = a<<0x00 | b<<0x08 | c<<0x16 | d<<0x24
+	// One byte per lane
+	var a = uint32(rand.Intn(0x0FFF)) & 0xFF
+	var b = uint32(rand.Intn(0x0FFF)) & 0xFF
+	var c = uint32(rand.Intn(0x0FFF)) & 0xFF
+	var d = uint32(rand.Intn(0x0FFF)) & 0xFF
+	var r = a<<0x00 | b<<0x08 | c<<0x10 | d<<0x18
 	return (r ^ value) & 0xFFFFFFFF
 }
 
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"mcmcx.com/mserver/modules/zinx/zpack"
@@ -173,10 +174,11 @@ func send_ping(conn net.Conn) int {
 //   - Server ID (int)
 //   - Server Token (MD5 16bytes)
 //   - User Remote Address (string)
-//   - User Authentication Token (MD5 string)
+//   - User Ticket (signed string, server_user_token)
 //   - User PublicKey (ECC bytes)
+//   - Trace ID (string, from HTTP auth)
 func send_auth(conn net.Conn, idx string, server_id int32, server_token string,
-	address string, token string) int {
+	address string, token string, trace_id string) int {
 	dp := zpack.NewDataPack(4096)
 
 	buffer := zpack.NewMessageBuffer(nil)
@@ -189,6 +191,7 @@ func send_auth(conn net.Conn, idx string, server_id int32, server_token string,
 	buffer.WriteStringL(address)
 	buffer.WriteStringL(token)
 	buffer.WriteBytesL([]byte(""))
+	buffer.WriteStringL(trace_id)
 
 	pack, _ := dp.Pack(zpack.NewMsgPackage(0x09, buffer.Data()))
 	len, err := conn.Write(pack)
@@ -274,8 +277,10 @@ func main() {
 		return
 	}
 
-	var server_address = fmt.Sprintf("%s:%d",
-		data["server_address"].(string), int(data["server_port"].(float64)))
+	var server_address = net.JoinHostPort(data["server_address"].(string),
+		strconv.Itoa(int(data["server_port"].(float64))))
+	trace_id, _ := data["trace_id"].(string)
+	println("(Test) Trace ID:", trace_id)
 
 	//
 	conn, err := net.Dial("tcp", server_address)
@@ -289,7 +294,7 @@ func main() {
 		//send_hello(conn)
 		//send_ping(conn)
 		send_auth(conn, data["idx"].(string), int32(data["server_id"].(float64)), data["server_token"].(string),
-			data["address"].(string), data["server_user_token"].(string))
+			data["address"].(string), data["server_user_token"].(string), trace_id)
 
 		var message *zpack.Message
 		var buffer *zpack.MessageBuffer
@@ -311,6 +316,27 @@ func main() {
 				tm64 := buffer.ReadUInt64()
 				println("(Test) Handler : (Ping) ", tm32, tm64)
 				break
+			case 0x02:
+				result := buffer.ReadInt32()
+				tm32 := buffer.ReadUInt32()
+				server_id := buffer.ReadInt32()
+				server_name := buffer.ReadStringL()
+				server_address := buffer.ReadStringL()
+				server_port := buffer.ReadInt32()
+				println("(Test) Handler : (Full) Result :", result, ", ", tm32,
+					"Alternative:", server_id, " - ", server_name, server_address, server_port)
+				break
+			case 0x03:
+				maintenance := buffer.ReadInt32()
+				tm32 := buffer.ReadUInt32()
+				message := buffer.ReadStringL()
+				println("(Test) Handler : (Maintenance)", maintenance, ", ", tm32, message)
+				break
+			case 0x04:
+				tm32 := buffer.ReadUInt32()
+				message := buffer.ReadStringL()
+				println("(Test) Handler : (System)", tm32, message)
+				break
 			case 0x09:
 				result := buffer.ReadInt32()
 				tm32 := buffer.ReadUInt32()
@@ -319,8 +345,11 @@ func main() {
 					if result >= 1 {
 						server_id := buffer.ReadInt32()
 						server_name := buffer.ReadStringL()
+						trace_id := buffer.ReadStringL()
+						server_pkey := buffer.ReadBytesL()
 						println("(Test) Handler : (Auth) Result :", result, ", ", tm32,
-							"idx:", idx, "Server:", server_id, " - ", server_name)
+							"idx:", idx, "Server:", server_id, " - ", server_name, "trace:", trace_id,
+							"pkey:", len(server_pkey))
 
 						send_user(conn)
 					} else {